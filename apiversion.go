@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errgo"
+)
+
+const DefaultAPIVersionHeader = "API-Version"
+
+type APIVersionOptions struct {
+	// Min and Max are the oldest and newest supported versions, e.g. "1.2" and
+	// "2". Versions consist of up to three numeric components, optionally
+	// prefixed by "v". Missing components are treated as 0. Both bounds are
+	// inclusive, an empty bound is unlimited.
+	Min string
+	Max string
+
+	// Header is the request header holding the version. Defaults to
+	// DefaultAPIVersionHeader.
+	Header string
+
+	// Default is the version assumed for requests without the header. If
+	// empty, such requests are rejected.
+	Default string
+}
+
+// NewAPIVersionMiddleware provides a middleware that rejects requests asking
+// for an API version outside of the supported range with an error with the
+// cause UnsupportedAPIVersionError, which responds with
+// http.StatusBadRequest and the supported range. Malformed versions are
+// rejected as well, missing ones only if there is no default. The requested
+// version is stored in `ctx.APIVersion`, for the following middlewares to
+// branch on. Malformed options panic.
+func NewAPIVersionMiddleware(options APIVersionOptions) Middleware {
+	if options.Header == "" {
+		options.Header = DefaultAPIVersionHeader
+	}
+
+	min := mustParseAPIVersion("min", options.Min)
+	max := mustParseAPIVersion("max", options.Max)
+	mustParseAPIVersion("default", options.Default)
+
+	supported := supportedAPIVersions(options.Min, options.Max)
+
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		value := strings.TrimSpace(req.Header.Get(options.Header))
+		if value == "" {
+			value = options.Default
+		}
+		if value == "" {
+			return errgo.WithCausef(nil, UnsupportedAPIVersionError, "missing %s header, supported versions: %s", options.Header, supported)
+		}
+
+		version, ok := parseAPIVersion(value)
+		if !ok {
+			return errgo.WithCausef(nil, UnsupportedAPIVersionError, "malformed API version '%s', supported versions: %s", value, supported)
+		}
+		if (options.Min != "" && version.less(min)) || (options.Max != "" && max.less(version)) {
+			return errgo.WithCausef(nil, UnsupportedAPIVersionError, "unsupported API version '%s', supported versions: %s", value, supported)
+		}
+
+		ctx.APIVersion = value
+
+		return ctx.Next()
+	}
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// apiVersion holds the major, minor and patch components of a version.
+type apiVersion [3]int
+
+func parseAPIVersion(s string) (apiVersion, bool) {
+	var v apiVersion
+
+	parts := strings.Split(strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V"), ".")
+	if len(parts) > len(v) {
+		return v, false
+	}
+
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v[i] = n
+	}
+
+	return v, true
+}
+
+// mustParseAPIVersion parses the given version option, unless it is empty.
+func mustParseAPIVersion(name, s string) apiVersion {
+	if s == "" {
+		return apiVersion{}
+	}
+
+	v, ok := parseAPIVersion(s)
+	if !ok {
+		panic(fmt.Sprintf("Invalid %s API version '%s'. Aborting...", name, s))
+	}
+
+	return v
+}
+
+func (v apiVersion) less(other apiVersion) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] < other[i]
+		}
+	}
+
+	return false
+}
+
+func supportedAPIVersions(min, max string) string {
+	switch {
+	case min == "" && max == "":
+		return "any"
+	case min == "":
+		return "up to " + max
+	case max == "":
+		return min + " and newer"
+	}
+
+	return min + " to " + max
+}
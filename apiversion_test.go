@@ -0,0 +1,94 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("API version middleware", func() {
+	var (
+		ts  *httptest.Server
+		srv *srvPkg.Server
+	)
+
+	request := func(path, version string) (int, string) {
+		req := test.Get(ts.URL + path)
+		if version != "" {
+			req.Header.Set("API-Version", version)
+		}
+
+		res, body := test.ProcessRequest(req)
+		return res.StatusCode, body
+	}
+
+	BeforeEach(func() {
+		logger := requestcontext.MustGetLogger(requestcontext.LoggerConfig{
+			Name:  "test",
+			Level: "critical",
+		})
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(logger)
+
+		handler := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.Response.PlainText(ctx.APIVersion, http.StatusOK)
+		}
+		srv.Serve("GET", "/strict", srvPkg.NewAPIVersionMiddleware(srvPkg.APIVersionOptions{Min: "1.2", Max: "2"}), handler)
+		srv.Serve("GET", "/lenient", srvPkg.NewAPIVersionMiddleware(srvPkg.APIVersionOptions{Min: "1.2", Default: "1.2"}), handler)
+
+		ts = test.NewServer(nil)
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("should accept versions within the range", func() {
+		for _, version := range []string{"1.2", "v1.5.3", "2", "2.0.0"} {
+			code, body := request("/strict", version)
+			Expect(code).To(Equal(http.StatusOK), version)
+			Expect(body).To(Equal(version))
+		}
+	})
+
+	It("should reject versions outside of the range", func() {
+		for _, version := range []string{"1.1.9", "2.0.1", "3"} {
+			code, body := request("/strict", version)
+			Expect(code).To(Equal(http.StatusBadRequest), version)
+			Expect(body).To(Equal("unsupported API version '" + version + "', supported versions: 1.2 to 2"))
+		}
+	})
+
+	It("should reject malformed versions", func() {
+		code, body := request("/strict", "latest")
+		Expect(code).To(Equal(http.StatusBadRequest))
+		Expect(body).To(ContainSubstring("malformed API version 'latest'"))
+	})
+
+	It("should reject missing versions without default", func() {
+		code, body := request("/strict", "")
+		Expect(code).To(Equal(http.StatusBadRequest))
+		Expect(body).To(ContainSubstring("missing API-Version header"))
+	})
+
+	It("should use the default for missing versions", func() {
+		code, body := request("/lenient", "")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("1.2"))
+	})
+
+	It("should panic for malformed options", func() {
+		Expect(func() {
+			srvPkg.NewAPIVersionMiddleware(srvPkg.APIVersionOptions{Max: "two"})
+		}).To(Panic())
+	})
+})
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// AssetCacheControl is the Cache-Control header value used for fingerprinted
+// assets. Their content never changes under the same URL.
+const AssetCacheControl = "public, max-age=31536000, immutable"
+
+type assetMount struct {
+	urlPath  string
+	manifest map[string]string
+}
+
+// ServeStaticAssets registers a middleware that serves files from the
+// filesystem, like ServeStatic does. Additionally the given manifest maps
+// logical file names to fingerprinted ones, e.g. "js/app.js" to
+// "js/app.abc123.js". Requests for a fingerprinted name are served from the
+// logical file, with a Cache-Control header allowing clients to cache it
+// forever. Use AssetURL to generate the URL of an asset.
+// Example: s.ServeStaticAssets("/assets", "./public/", manifest)
+func (s *Server) ServeStaticAssets(urlPath, fsPath string, manifest map[string]string) {
+	logical := map[string]string{}
+	for name, fingerprinted := range manifest {
+		logical[strings.TrimPrefix(fingerprinted, "/")] = name
+	}
+
+	fileServer := http.FileServer(http.Dir(fsPath))
+	handler := http.StripPrefix(urlPath, http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if name, ok := logical[strings.TrimPrefix(req.URL.Path, "/")]; ok {
+			res.Header().Set("Cache-Control", AssetCacheControl)
+			req.URL.Path = "/" + strings.TrimPrefix(name, "/")
+		}
+
+		fileServer.ServeHTTP(res, req)
+	}))
+	s.Router.Methods("GET").PathPrefix(urlPath).Handler(handler)
+
+	s.assetMounts = append(s.assetMounts, assetMount{urlPath: urlPath, manifest: manifest})
+}
+
+// AssetURL returns the URL path of the asset with the given logical name, as
+// registered using ServeStaticAssets, including the base path. If the name is
+// not part of any manifest, the URL path of the unfingerprinted file below the
+// first asset mount is returned.
+func (s *Server) AssetURL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+
+	for _, mount := range s.assetMounts {
+		if fingerprinted, ok := mount.manifest[name]; ok {
+			return path.Join("/", s.basePath, mount.urlPath, fingerprinted)
+		}
+	}
+
+	if len(s.assetMounts) > 0 {
+		return path.Join("/", s.basePath, s.assetMounts[0].urlPath, name)
+	}
+
+	return path.Join("/", name)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCircuitBreakerThreshold = 5
+	DefaultCircuitBreakerWindow    = 10 * time.Second
+	DefaultCircuitBreakerCooldown  = 30 * time.Second
+)
+
+type CircuitBreakerOptions struct {
+	// Threshold is the number of failures within Window that opens the circuit.
+	Threshold int
+
+	// Window is the period failures are counted in.
+	Window time.Duration
+
+	// Cooldown is the period the circuit stays open before a single trial
+	// request is let through again.
+	Cooldown time.Duration
+}
+
+// NewCircuitBreakerMiddleware provides a middleware that protects the
+// downstream chain, e.g. a reverse proxy, from being called while it is
+// failing. A request fails when a downstream middleware panics, or responds
+// with a 5xx status code, also using `ctx.Fail()` or by returning an error.
+// Returned client errors like InvalidParamError do not count as failures.
+// Once the configured number of failures occurred within the window, the
+// circuit opens and requests are answered with http.StatusServiceUnavailable
+// for the cooldown period. Afterwards the circuit is half-open. A single
+// trial request is let through, which closes the circuit on success and opens
+// it again on failure. Zero options fall back to the defaults above.
+func NewCircuitBreakerMiddleware(options CircuitBreakerOptions) Middleware {
+	if options.Threshold <= 0 {
+		options.Threshold = DefaultCircuitBreakerThreshold
+	}
+	if options.Window <= 0 {
+		options.Window = DefaultCircuitBreakerWindow
+	}
+	if options.Cooldown <= 0 {
+		options.Cooldown = DefaultCircuitBreakerCooldown
+	}
+
+	cb := &circuitBreaker{options: options}
+
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		if wait, ok := cb.allow(time.Now()); !ok {
+			res.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			return ctx.Fail(http.StatusServiceUnavailable, "service unavailable")
+		}
+
+		// A panic of a downstream middleware is recorded as failure, so a
+		// trial request does not leave the circuit half-open.
+		success := false
+		defer func() {
+			cb.record(time.Now(), success)
+		}()
+
+		// Requests are classified by the status code they are responded with,
+		// so client errors like 403 or 400 do not open the circuit.
+		err := ctx.Proceed()
+		code := ctx.recorder.statusCode
+		if err != nil && !isHandled(err) {
+			code = errorStatusCode(err)
+		}
+		success = code < 500
+
+		return err
+	}
+}
+
+//------------------------------------------------------------------------------
+// private
+
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+type circuitBreaker struct {
+	options CircuitBreakerOptions
+
+	mutex       sync.Mutex
+	state       int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+}
+
+// allow returns true if a request is allowed to pass. Otherwise the time to
+// wait until the next trial request is returned.
+func (cb *circuitBreaker) allow(now time.Time) (time.Duration, bool) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	switch cb.state {
+	case circuitOpen:
+		if wait := cb.openedAt.Add(cb.options.Cooldown).Sub(now); wait > 0 {
+			return wait, false
+		}
+
+		// Let a single trial request through.
+		cb.state = circuitHalfOpen
+		return 0, true
+	case circuitHalfOpen:
+		// A trial request is in progress.
+		return cb.options.Cooldown, false
+	}
+
+	return 0, true
+}
+
+// record tracks the outcome of a request that was allowed to pass.
+func (cb *circuitBreaker) record(now time.Time, success bool) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.state == circuitHalfOpen {
+		if success {
+			cb.state = circuitClosed
+			cb.failures = 0
+		} else {
+			cb.state = circuitOpen
+			cb.openedAt = now
+		}
+		return
+	}
+
+	if success {
+		return
+	}
+
+	if now.Sub(cb.windowStart) > cb.options.Window {
+		cb.windowStart = now
+		cb.failures = 0
+	}
+
+	cb.failures++
+	if cb.failures >= cb.options.Threshold {
+		cb.state = circuitOpen
+		cb.openedAt = now
+	}
+}
@@ -0,0 +1,138 @@
+package server_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+	"github.com/juju/errgo"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("circuit breaker middleware", func() {
+	var (
+		ts      *httptest.Server
+		srv     *srvPkg.Server
+		failing bool
+		outcome string
+		calls   int
+	)
+
+	request := func() int {
+		code, _, _ := test.NewGetRequest(ts.URL + "/upstream")
+		return code
+	}
+
+	BeforeEach(func() {
+		failing = true
+		outcome = ""
+		calls = 0
+
+		ts = test.NewServer(nil)
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		breaker := srvPkg.NewCircuitBreakerMiddleware(srvPkg.CircuitBreakerOptions{
+			Threshold: 2,
+			Window:    time.Minute,
+			Cooldown:  50 * time.Millisecond,
+		})
+		srv.Serve("GET", "/upstream", breaker, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			calls++
+			switch outcome {
+			case "forbidden":
+				return ctx.Fail(http.StatusForbidden, "access denied")
+			case "invalid":
+				return errgo.WithCausef(nil, srvPkg.InvalidParamError, "invalid id")
+			case "panic":
+				panic("upstream panicked")
+			}
+			if failing {
+				return errors.New("upstream failed")
+			}
+			return ctx.Response.PlainText("OK", http.StatusOK)
+		})
+
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("should open the circuit once the threshold is reached", func() {
+		Expect(request()).To(Equal(http.StatusInternalServerError))
+		Expect(request()).To(Equal(http.StatusInternalServerError))
+
+		Expect(request()).To(Equal(http.StatusServiceUnavailable))
+		Expect(calls).To(Equal(2))
+	})
+
+	It("should close the circuit after a successful trial request", func() {
+		request()
+		request()
+		failing = false
+
+		time.Sleep(60 * time.Millisecond)
+
+		Expect(request()).To(Equal(http.StatusOK))
+		Expect(request()).To(Equal(http.StatusOK))
+		Expect(calls).To(Equal(4))
+	})
+
+	It("should open the circuit again after a failing trial request", func() {
+		request()
+		request()
+
+		time.Sleep(60 * time.Millisecond)
+
+		Expect(request()).To(Equal(http.StatusInternalServerError))
+		Expect(request()).To(Equal(http.StatusServiceUnavailable))
+		Expect(calls).To(Equal(3))
+	})
+
+	It("should not open the circuit for client errors responded using ctx.Fail", func() {
+		outcome = "forbidden"
+
+		Expect(request()).To(Equal(http.StatusForbidden))
+		Expect(request()).To(Equal(http.StatusForbidden))
+		Expect(request()).To(Equal(http.StatusForbidden))
+		Expect(calls).To(Equal(3))
+	})
+
+	It("should not open the circuit for returned client errors", func() {
+		outcome = "invalid"
+
+		Expect(request()).To(Equal(http.StatusBadRequest))
+		Expect(request()).To(Equal(http.StatusBadRequest))
+		Expect(request()).To(Equal(http.StatusBadRequest))
+		Expect(calls).To(Equal(3))
+	})
+
+	It("should open the circuit again after a panicking trial request", func() {
+		request()
+		request()
+
+		time.Sleep(60 * time.Millisecond)
+
+		outcome = "panic"
+		Expect(request()).To(Equal(http.StatusInternalServerError))
+		Expect(request()).To(Equal(http.StatusServiceUnavailable))
+
+		// The next trial request is let through after the cooldown.
+		outcome = ""
+		failing = false
+		time.Sleep(60 * time.Millisecond)
+
+		Expect(request()).To(Equal(http.StatusOK))
+		Expect(calls).To(Equal(4))
+	})
+})
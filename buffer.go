@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/juju/errgo"
+)
+
+// NewResponseBufferMiddleware provides a middleware that buffers the response
+// of the following middlewares and writes it once they finished. Until then,
+// middlewares running after this one can read and modify the buffered
+// response using `ctx.ResponseStatus()`, `ctx.SetResponseStatus()`,
+// `ctx.ResponseBody()` and `ctx.SetResponseBody()`, e.g. once `ctx.Proceed()`
+// returned. Because of that, the following middlewares can neither stream
+// responses nor hijack the connection.
+// Example: s.Serve("GET", "/v1/items", server.NewResponseBufferMiddleware(), emptyOnNotFound, listItems)
+func NewResponseBufferMiddleware() Middleware {
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		if ctx.ResponseWritten() {
+			return ctx.Proceed()
+		}
+
+		buf, restore := ctx.bufferResponse()
+		err := ctx.Proceed()
+		restore()
+
+		if flushErr := buf.flushTo(ctx.recorder); flushErr != nil {
+			return errgo.Mask(flushErr)
+		}
+
+		return err
+	}
+}
+
+// ResponseStatus returns the status code of the response written so far, or 0
+// if none was written yet. If the response is buffered, this is the buffered
+// status code, which can still be changed using SetResponseStatus.
+func (c *Context) ResponseStatus() int {
+	if buf, ok := c.responseBuffer(); ok {
+		return buf.statusCode
+	}
+
+	return c.recorder.statusCode
+}
+
+// SetResponseStatus replaces the status code of the buffered response. This
+// only works for middlewares running after the response buffer middleware, as
+// the status code of an unbuffered response is sent to the client already.
+// Otherwise an error with the cause ResponseNotBufferedError is returned.
+func (c *Context) SetResponseStatus(code int) error {
+	buf, ok := c.responseBuffer()
+	if !ok {
+		return errgo.WithCausef(nil, ResponseNotBufferedError, "cannot set response status")
+	}
+
+	buf.statusCode = code
+
+	return nil
+}
+
+// ResponseBody returns the body of the buffered response. The returned slice
+// must not be modified, use SetResponseBody instead. If the response is not
+// buffered, an error with the cause ResponseNotBufferedError is returned.
+func (c *Context) ResponseBody() ([]byte, error) {
+	buf, ok := c.responseBuffer()
+	if !ok {
+		return nil, errgo.WithCausef(nil, ResponseNotBufferedError, "cannot read response body")
+	}
+
+	body, err := buf.bytes()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+
+	return body, nil
+}
+
+// SetResponseBody replaces the body of the buffered response. A Content-Length
+// header set by a previous middleware is updated accordingly. If the response
+// is not buffered, an error with the cause ResponseNotBufferedError is
+// returned.
+func (c *Context) SetResponseBody(body []byte) error {
+	buf, ok := c.responseBuffer()
+	if !ok {
+		return errgo.WithCausef(nil, ResponseNotBufferedError, "cannot set response body")
+	}
+
+	if err := buf.replace(body); err != nil {
+		return errgo.Mask(err)
+	}
+
+	if buf.header.Get("Content-Length") != "" {
+		buf.header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	return nil
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// responseBuffer returns the buffer the response is currently written to, if
+// any, even if a middleware wraps the response writer in front of it.
+// Besides the response buffer middleware, this is also the buffer of the
+// retry, idempotency or single flight middleware.
+func (c *Context) responseBuffer() (*responseBuffer, bool) {
+	if len(c.buffers) == 0 {
+		return nil, false
+	}
+
+	return c.buffers[len(c.buffers)-1], true
+}
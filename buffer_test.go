@@ -0,0 +1,123 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("response buffer middleware", func() {
+	var (
+		ts  *httptest.Server
+		srv *srvPkg.Server
+	)
+
+	notFound := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+		res.Header().Set("Content-Length", "9")
+		return ctx.Response.PlainText("not found", http.StatusNotFound)
+	}
+
+	// emptyOnNotFound maps a 404 of the following middlewares to an empty
+	// result.
+	emptyOnNotFound := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+		if err := ctx.Proceed(); err != nil {
+			return err
+		}
+
+		if ctx.ResponseStatus() != http.StatusNotFound {
+			return nil
+		}
+
+		if err := ctx.SetResponseStatus(http.StatusOK); err != nil {
+			return err
+		}
+		return ctx.SetResponseBody([]byte("[]"))
+	}
+
+	BeforeEach(func() {
+		logger := requestcontext.MustGetLogger(requestcontext.LoggerConfig{
+			Name:  "test",
+			Level: "critical",
+		})
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(logger)
+
+		ts = test.NewServer(nil)
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("Should allow middlewares to rewrite the buffered status and body", func() {
+		srv.Serve("GET", "/items", srvPkg.NewResponseBufferMiddleware(), emptyOnNotFound, notFound)
+		ts.Config.Handler = srv.Router
+
+		res, body := test.ProcessRequest(test.Get(ts.URL + "/items"))
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("[]"))
+		Expect(res.Header.Get("Content-Length")).To(Equal("2"))
+	})
+
+	It("Should find the buffer behind wrapping response writers", func() {
+		srv.Serve("GET", "/items", srvPkg.NewResponseBufferMiddleware(), srvPkg.NewServerTimingMiddleware(), emptyOnNotFound, notFound)
+		ts.Config.Handler = srv.Router
+
+		res, body := test.ProcessRequest(test.Get(ts.URL + "/items"))
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("[]"))
+		Expect(res.Header.Get("Server-Timing")).NotTo(BeEmpty())
+	})
+
+	It("Should keep responses that are not rewritten", func() {
+		srv.Serve("GET", "/items", srvPkg.NewResponseBufferMiddleware(), emptyOnNotFound, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.Response.PlainText("item", http.StatusOK)
+		})
+		ts.Config.Handler = srv.Router
+
+		res, body := test.ProcessRequest(test.Get(ts.URL + "/items"))
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("item"))
+	})
+
+	It("Should expose the buffered body", func() {
+		srv.Serve("GET", "/items", srvPkg.NewResponseBufferMiddleware(), func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			if err := ctx.Proceed(); err != nil {
+				return err
+			}
+
+			body, err := ctx.ResponseBody()
+			if err != nil {
+				return err
+			}
+			return ctx.SetResponseBody(bytes.ToUpper(body))
+		}, notFound)
+		ts.Config.Handler = srv.Router
+
+		res, body := test.ProcessRequest(test.Get(ts.URL + "/items"))
+		Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+		Expect(body).To(Equal("NOT FOUND"))
+	})
+
+	It("Should fail to rewrite unbuffered responses", func() {
+		var setErr error
+		srv.Serve("GET", "/items", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			setErr = ctx.SetResponseStatus(http.StatusOK)
+			return ctx.Proceed()
+		}, notFound)
+		ts.Config.Handler = srv.Router
+
+		res, _ := test.ProcessRequest(test.Get(ts.URL + "/items"))
+		Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+		Expect(srvPkg.IsResponseNotBuffered(setErr)).To(BeTrue())
+	})
+})
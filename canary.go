@@ -0,0 +1,80 @@
+package server
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strconv"
+)
+
+const (
+	DefaultCanaryHeader = "X-Canary"
+	DefaultCanaryCookie = "canary"
+)
+
+type CanaryOptions struct {
+	// Percent of requests sent through the canary middleware.
+	Percent int
+
+	// Key returns a stable key for the request, like a session ID. Requests
+	// with the same key are always sent through the same middleware. Requests
+	// for which an empty key is returned are distributed randomly. Defaults
+	// to the client IP.
+	Key func(req *http.Request, ctx *Context) string
+
+	// Header and Cookie name a request header and cookie that override the
+	// decision for testing, if set to a boolean like "true" or "false".
+	// Default to DefaultCanaryHeader and DefaultCanaryCookie.
+	Header string
+	Cookie string
+}
+
+// NewCanaryMiddleware provides a middleware that sends `options.Percent` of
+// the requests through the canary middleware and the rest through the stable
+// one, which allows rolling out new handler logic gradually.
+// Example: s.Serve("GET", "/v1/search", server.NewCanaryMiddleware(server.CanaryOptions{Percent: 5}, newSearch, search))
+func NewCanaryMiddleware(options CanaryOptions, canary, stable Middleware) Middleware {
+	if options.Key == nil {
+		options.Key = func(req *http.Request, ctx *Context) string {
+			return ctx.ClientIP()
+		}
+	}
+	if options.Header == "" {
+		options.Header = DefaultCanaryHeader
+	}
+	if options.Cookie == "" {
+		options.Cookie = DefaultCanaryCookie
+	}
+
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		if isCanary(options, req, ctx) {
+			return canary(res, req, ctx)
+		}
+
+		return stable(res, req, ctx)
+	}
+}
+
+//------------------------------------------------------------------------------
+// private
+
+func isCanary(options CanaryOptions, req *http.Request, ctx *Context) bool {
+	if force, err := strconv.ParseBool(req.Header.Get(options.Header)); err == nil {
+		return force
+	}
+	if cookie, err := req.Cookie(options.Cookie); err == nil {
+		if force, err := strconv.ParseBool(cookie.Value); err == nil {
+			return force
+		}
+	}
+
+	key := options.Key(req, ctx)
+	if key == "" {
+		return rand.Intn(100) < options.Percent
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return int(h.Sum32()%100) < options.Percent
+}
@@ -0,0 +1,80 @@
+package server_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("canary middleware", func() {
+	var ts *httptest.Server
+
+	BeforeEach(func() {
+		ts = test.NewServer(nil)
+
+		srv := srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		canary := srvPkg.NewCanaryMiddleware(srvPkg.CanaryOptions{
+			Percent: 20,
+			Key: func(req *http.Request, ctx *srvPkg.Context) string {
+				return req.Header.Get("X-User")
+			},
+		}, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.Response.PlainText("canary", http.StatusOK)
+		}, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.Response.PlainText("stable", http.StatusOK)
+		})
+		srv.Serve("GET", "/search", canary)
+
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	get := func(header map[string]string) string {
+		req := test.Get(ts.URL + "/search")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		_, body := test.ProcessRequest(req)
+		return body
+	}
+
+	It("should send the configured percentage through the canary", func() {
+		canaries := 0
+		for i := 0; i < 500; i++ {
+			if get(map[string]string{"X-User": fmt.Sprintf("user-%d", i)}) == "canary" {
+				canaries++
+			}
+		}
+
+		Expect(canaries).To(BeNumerically("~", 100, 30))
+	})
+
+	It("should always send the same key through the same middleware", func() {
+		first := get(map[string]string{"X-User": "alice"})
+		for i := 0; i < 10; i++ {
+			Expect(get(map[string]string{"X-User": "alice"})).To(Equal(first))
+		}
+	})
+
+	It("should honor the override header and cookie", func() {
+		for i := 0; i < 10; i++ {
+			user := fmt.Sprintf("user-%d", i)
+			Expect(get(map[string]string{"X-User": user, srvPkg.DefaultCanaryHeader: "true"})).To(Equal("canary"))
+			Expect(get(map[string]string{"X-User": user, srvPkg.DefaultCanaryHeader: "false"})).To(Equal("stable"))
+			Expect(get(map[string]string{"X-User": user, "Cookie": srvPkg.DefaultCanaryCookie + "=1"})).To(Equal("canary"))
+		}
+	})
+})
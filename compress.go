@@ -0,0 +1,506 @@
+package server
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/juju/errgo"
+)
+
+const (
+	EncodingGzip     = "gzip"
+	EncodingDeflate  = "deflate"
+	EncodingIdentity = "identity"
+)
+
+// supportedEncodings lists the content encodings in order of preference.
+var supportedEncodings = []string{EncodingGzip, EncodingDeflate, EncodingIdentity}
+
+// NewDecompressReader wraps the given reader to decompress its content
+// according to the given content encoding, as found in the Content-Encoding
+// header of a request or response. An empty encoding is treated as identity.
+// The returned reader must be closed by the caller. An error with the cause
+// UnsupportedEncodingError is returned for unknown encodings.
+func NewDecompressReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch normalizeEncoding(encoding) {
+	case EncodingGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return gr, nil
+	case EncodingDeflate:
+		return flate.NewReader(r), nil
+	case EncodingIdentity:
+		return ioutil.NopCloser(r), nil
+	}
+
+	return nil, errgo.WithCausef(nil, UnsupportedEncodingError, "unsupported encoding '%s'", encoding)
+}
+
+// NewCompressWriter wraps the given writer to compress everything written to
+// it according to the given content encoding. The returned writer must be
+// closed to flush the compressed content to w. Gzip writers are pooled, so
+// closing the writer also makes it available to be reused. An error with the cause
+// UnsupportedEncodingError is returned for unknown encodings.
+func NewCompressWriter(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch normalizeEncoding(encoding) {
+	case EncodingGzip:
+		gw := gzipWriterPool.Get().(*gzip.Writer)
+		gw.Reset(w)
+		return &pooledGzipWriter{gw: gw}, nil
+	case EncodingDeflate:
+		fw, err := flate.NewWriter(w, flate.DefaultCompression)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return fw, nil
+	case EncodingIdentity:
+		return nopWriteCloser{w}, nil
+	}
+
+	return nil, errgo.WithCausef(nil, UnsupportedEncodingError, "unsupported encoding '%s'", encoding)
+}
+
+// NegotiateEncoding returns the supported content encoding preferred by the
+// given Accept-Encoding header value, respecting quality values. Identity is
+// returned when the client does not accept any compressed encoding.
+//
+// A proxying middleware can combine the helpers above to normalize the
+// encoding of an upstream response:
+//
+//	body, err := server.NewDecompressReader(upstream.Header.Get("Content-Encoding"), upstream.Body)
+//	...
+//	encoding := server.NegotiateEncoding(req.Header.Get("Accept-Encoding"))
+//	res.Header().Set("Content-Encoding", encoding)
+//	w, err := server.NewCompressWriter(encoding, res)
+//	...
+//	io.Copy(w, body)
+//	w.Close()
+func NegotiateEncoding(acceptEncoding string) string {
+	qualities := parseAcceptEncoding(acceptEncoding)
+
+	best := EncodingIdentity
+	bestQ := 0.0
+	for _, encoding := range supportedEncodings {
+		q, ok := qualities[encoding]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		if !ok && encoding == EncodingIdentity {
+			// Identity is always acceptable, unless explicitly excluded.
+			q, ok = 0.001, true
+		}
+
+		if ok && q > bestQ {
+			best = encoding
+			bestQ = q
+		}
+	}
+
+	return best
+}
+
+// DefaultCompressSkipContentTypes lists content types that are usually
+// compressed already and do not benefit from being compressed again.
+var DefaultCompressSkipContentTypes = []string{
+	"image/*",
+	"video/*",
+	"audio/*",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-rar-compressed",
+	"application/x-7z-compressed",
+}
+
+// CompressOptions configures the compress middleware.
+type CompressOptions struct {
+	// SkipContentTypes lists the content types of responses that are not
+	// compressed. A trailing "/*" matches all subtypes. Defaults to
+	// DefaultCompressSkipContentTypes.
+	SkipContentTypes []string
+}
+
+// NewCompressMiddleware provides a middleware that compresses the responses of
+// the following middlewares using the encoding preferred by the client's
+// Accept-Encoding header. Responses whose content type is listed in
+// `options.SkipContentTypes` and responses that already have a
+// Content-Encoding are passed through unchanged. Requests without or with
+// only unsupported encodings are responded uncompressed, unless identity is
+// explicitly rejected, e.g. using "identity;q=0". Then an error with the
+// cause NotAcceptableError is returned, which responds with
+// http.StatusNotAcceptable. Responses eligible for compression carry a
+// "Vary: Accept-Encoding" header, even if the client gets them uncompressed.
+//
+// The decision to compress is made when the first chunk of the body is
+// written. At that point an explicitly set Content-Type header is honored,
+// otherwise the content type is sniffed from the first chunk using
+// http.DetectContentType. Sniffing only sees what was passed to the first
+// Write, so handlers writing non-trivial chunks of binary content should set
+// the Content-Type header explicitly. To be able to still set the
+// Content-Encoding header, writing the status code is delayed until the first
+// chunk of the body is written as well.
+func NewCompressMiddleware(options CompressOptions) Middleware {
+	if options.SkipContentTypes == nil {
+		options.SkipContentTypes = DefaultCompressSkipContentTypes
+	}
+
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) (err error) {
+		acceptEncoding := req.Header.Get("Accept-Encoding")
+		encoding := NegotiateEncoding(acceptEncoding)
+		if encoding == EncodingIdentity && !acceptsIdentity(acceptEncoding) {
+			return errgo.WithCausef(nil, NotAcceptableError, "not acceptable: no supported content encoding accepted")
+		}
+
+		// Responses are wrapped even if they are not compressed, to add the
+		// Vary header to the ones that would be compressed for other clients.
+
+		orig := ctx.recorder.ResponseWriter
+		cw := &compressWriter{
+			ResponseWriter: orig,
+			encoding:       encoding,
+			skip:           options.SkipContentTypes,
+		}
+
+		ctx.recorder.ResponseWriter = cw
+		defer func() {
+			// Once the compressed body started, writing anything else, like an
+			// error message, would corrupt it. So the compress writer stays in
+			// place and discards further writes.
+			if cw.w == nil {
+				ctx.recorder.ResponseWriter = orig
+			}
+
+			if closeErr := cw.close(); closeErr != nil && err == nil {
+				err = errgo.Mask(closeErr)
+			}
+
+			// Errors caused by a client going away are not worth being
+			// responded or logged as server errors.
+			if err != nil && isDisconnect(cw.err) {
+				ctx.server.Logger.Debug(ctx.Request, "client disconnected during compressed response: %s", cw.err)
+				err = nil
+			}
+		}()
+
+		return ctx.Proceed()
+	}
+}
+
+//------------------------------------------------------------------------------
+// private
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// pooledGzipWriter is a gzip writer taken from gzipWriterPool. It is returned
+// to the pool when it is closed, unless an error occurred, which a gzip writer
+// keeps returning until it is reset. Using the writer after closing it
+// returns an error, since the underlying writer might be in use by another
+// request already.
+type pooledGzipWriter struct {
+	gw     *gzip.Writer
+	failed bool
+}
+
+func (p *pooledGzipWriter) Write(b []byte) (int, error) {
+	if p.gw == nil {
+		return 0, errgo.New("write to closed gzip writer")
+	}
+
+	n, err := p.gw.Write(b)
+	if err != nil {
+		p.failed = true
+	}
+
+	return n, err
+}
+
+func (p *pooledGzipWriter) Flush() error {
+	if p.gw == nil {
+		return errgo.New("flush of closed gzip writer")
+	}
+
+	err := p.gw.Flush()
+	if err != nil {
+		p.failed = true
+	}
+
+	return err
+}
+
+func (p *pooledGzipWriter) Close() error {
+	if p.gw == nil {
+		return nil
+	}
+
+	gw := p.gw
+	p.gw = nil
+
+	err := gw.Close()
+	if err == nil && !p.failed {
+		// Drop the reference to the destination writer while pooled.
+		gw.Reset(nil)
+		gzipWriterPool.Put(gw)
+	}
+
+	return err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func normalizeEncoding(encoding string) string {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+
+	switch encoding {
+	case "", EncodingIdentity:
+		return EncodingIdentity
+	case "x-gzip":
+		return EncodingGzip
+	}
+
+	return encoding
+}
+
+// parseAcceptEncoding maps the codings of an Accept-Encoding header value to
+// their quality values.
+func parseAcceptEncoding(acceptEncoding string) map[string]float64 {
+	qualities := map[string]float64{}
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+		if coding == "x-gzip" {
+			coding = EncodingGzip
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+				q = v
+			}
+		}
+
+		qualities[coding] = q
+	}
+
+	return qualities
+}
+
+// acceptsIdentity returns false if the given Accept-Encoding header value
+// explicitly rejects the identity encoding, directly or via "*".
+func acceptsIdentity(acceptEncoding string) bool {
+	qualities := parseAcceptEncoding(acceptEncoding)
+
+	q, ok := qualities[EncodingIdentity]
+	if !ok {
+		q, ok = qualities["*"]
+	}
+
+	return !ok || q > 0
+}
+
+// compressWriter is a http.ResponseWriter that decides on the first write
+// whether to compress the response.
+type compressWriter struct {
+	http.ResponseWriter
+
+	encoding   string
+	skip       []string
+	statusCode int
+	decided    bool
+	closed     bool
+	w          io.WriteCloser
+
+	// The first error writing to the client.
+	err error
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if c.statusCode == 0 {
+		c.statusCode = code
+	}
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if c.closed {
+		return len(b), nil
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	if !c.decided {
+		if err := c.decide(b); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if c.w != nil {
+		n, err = c.w.Write(b)
+	} else {
+		n, err = c.ResponseWriter.Write(b)
+	}
+	if err != nil {
+		c.err = err
+	}
+
+	return n, err
+}
+
+func (c *compressWriter) Flush() {
+	if c.closed {
+		return
+	}
+
+	if !c.decided {
+		if err := c.decide(nil); err != nil {
+			return
+		}
+	}
+
+	if f, ok := c.w.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// decide sets up compression, if the response is worth compressing and the
+// client accepts a compressed encoding, and writes the status code. Such
+// responses get a Vary header, whether they are compressed or not, so shared
+// caches do not serve them to clients accepting another encoding.
+func (c *compressWriter) decide(b []byte) error {
+	c.decided = true
+
+	header := c.Header()
+	compressible := c.compressible(header, b)
+	if compressible {
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	if compressible && c.encoding != EncodingIdentity {
+		w, err := NewCompressWriter(c.encoding, c.ResponseWriter)
+		if err != nil {
+			return err
+		}
+
+		header.Set("Content-Encoding", c.encoding)
+		header.Del("Content-Length")
+		c.w = w
+	}
+
+	if c.statusCode != 0 {
+		c.ResponseWriter.WriteHeader(c.statusCode)
+	}
+
+	return nil
+}
+
+func (c *compressWriter) compressible(header http.Header, b []byte) bool {
+	if header.Get("Content-Encoding") != "" || len(b) == 0 {
+		return false
+	}
+
+	switch c.statusCode {
+	case http.StatusNoContent, http.StatusNotModified:
+		return false
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(b)
+	}
+
+	return !matchContentType(c.skip, contentType)
+}
+
+// close writes a delayed status code and finishes the compressed body.
+// Writes after closing are discarded.
+func (c *compressWriter) close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	if !c.decided {
+		c.decided = true
+
+		if c.statusCode != 0 {
+			c.ResponseWriter.WriteHeader(c.statusCode)
+		}
+	}
+
+	if c.w != nil && c.err == nil {
+		if err := c.w.Close(); err != nil {
+			c.err = err
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isDisconnect returns true if the given error was caused by the client
+// closing the connection.
+func isDisconnect(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, context.Canceled)
+}
+
+// matchContentType returns true if the given content type matches one of the
+// given patterns.
+func matchContentType(patterns []string, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+
+		if strings.HasSuffix(pattern, "/*") {
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if mediaType == pattern {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,305 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func BenchmarkNewCompressWriterGzip(b *testing.B) {
+	content := bytes.Repeat([]byte("hello world "), 1024)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w, _ := srvPkg.NewCompressWriter(srvPkg.EncodingGzip, ioutil.Discard)
+		w.Write(content)
+		w.Close()
+	}
+}
+
+var _ = Describe("compression helpers", func() {
+	Describe("round trip", func() {
+		for _, encoding := range []string{srvPkg.EncodingGzip, srvPkg.EncodingDeflate, srvPkg.EncodingIdentity} {
+			encoding := encoding
+
+			It("should decompress what was compressed using "+encoding, func() {
+				var buf bytes.Buffer
+
+				w, err := srvPkg.NewCompressWriter(encoding, &buf)
+				Expect(err).To(BeNil())
+				_, err = w.Write([]byte("hello world"))
+				Expect(err).To(BeNil())
+				Expect(w.Close()).To(Succeed())
+
+				r, err := srvPkg.NewDecompressReader(encoding, &buf)
+				Expect(err).To(BeNil())
+				content, err := ioutil.ReadAll(r)
+				Expect(err).To(BeNil())
+				Expect(r.Close()).To(Succeed())
+
+				Expect(string(content)).To(Equal("hello world"))
+			})
+		}
+
+		It("should not leak state between pooled gzip writers", func() {
+			decompress := func(buf *bytes.Buffer) string {
+				r, err := gzip.NewReader(buf)
+				Expect(err).To(BeNil())
+				content, err := ioutil.ReadAll(r)
+				Expect(err).To(BeNil())
+				return string(content)
+			}
+
+			// A writer failing to write is not put back into the pool.
+			w, err := srvPkg.NewCompressWriter(srvPkg.EncodingGzip, failingWriter{})
+			Expect(err).To(BeNil())
+			w.Write(bytes.Repeat([]byte("x"), 64*1024))
+			Expect(w.Close()).NotTo(Succeed())
+
+			for _, content := range []string{"first", "second"} {
+				var buf bytes.Buffer
+
+				w, err := srvPkg.NewCompressWriter(srvPkg.EncodingGzip, &buf)
+				Expect(err).To(BeNil())
+				_, err = w.Write([]byte(content))
+				Expect(err).To(BeNil())
+				Expect(w.Close()).To(Succeed())
+
+				_, err = w.Write([]byte("after close"))
+				Expect(err).NotTo(BeNil())
+
+				Expect(decompress(&buf)).To(Equal(content))
+			}
+		})
+
+		It("should reject unknown encodings", func() {
+			_, err := srvPkg.NewCompressWriter("br", &bytes.Buffer{})
+			Expect(srvPkg.IsUnsupportedEncoding(err)).To(BeTrue())
+
+			_, err = srvPkg.NewDecompressReader("br", &bytes.Buffer{})
+			Expect(srvPkg.IsUnsupportedEncoding(err)).To(BeTrue())
+		})
+	})
+
+	Describe("NegotiateEncoding", func() {
+		It("should fall back to identity", func() {
+			Expect(srvPkg.NegotiateEncoding("")).To(Equal(srvPkg.EncodingIdentity))
+			Expect(srvPkg.NegotiateEncoding("br")).To(Equal(srvPkg.EncodingIdentity))
+		})
+
+		It("should prefer gzip", func() {
+			Expect(srvPkg.NegotiateEncoding("deflate, gzip")).To(Equal(srvPkg.EncodingGzip))
+			Expect(srvPkg.NegotiateEncoding("*")).To(Equal(srvPkg.EncodingGzip))
+		})
+
+		It("should respect quality values", func() {
+			Expect(srvPkg.NegotiateEncoding("gzip;q=0.5, deflate")).To(Equal(srvPkg.EncodingDeflate))
+			Expect(srvPkg.NegotiateEncoding("gzip;q=0, deflate;q=0")).To(Equal(srvPkg.EncodingIdentity))
+		})
+	})
+
+	Describe("compress middleware", func() {
+		var (
+			ts        *httptest.Server
+			srv       *srvPkg.Server
+			streamErr chan error
+		)
+
+		BeforeEach(func() {
+			ts = test.NewServer(nil)
+			streamErr = make(chan error, 1)
+
+			srv = srvPkg.NewServer("", "")
+			srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+			compress := srvPkg.NewCompressMiddleware(srvPkg.CompressOptions{})
+			srv.Serve("GET", "/text", compress, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText("hello world", http.StatusCreated)
+			})
+			srv.Serve("GET", "/image", compress, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				res.Header().Set("Content-Type", "image/png")
+				return ctx.Response.PlainText("not really a png", http.StatusOK)
+			})
+			srv.Serve("GET", "/sniffed", compress, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText("GIF89a...", http.StatusOK)
+			})
+			srv.Serve("GET", "/partial", compress, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				ctx.Response.PlainText("partial", http.StatusOK)
+				return errors.New("test error")
+			})
+			srv.Serve("GET", "/stream", compress, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				chunk := make([]byte, 64*1024)
+				for i := 0; i < 1000; i++ {
+					rand.Read(chunk)
+					if _, err := res.Write(chunk); err != nil {
+						streamErr <- err
+						return err
+					}
+				}
+				streamErr <- nil
+				return nil
+			})
+			srv.ServeExpvar("/debug/vars")
+			srv.Serve("GET", "/encoded", compress, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				res.Header().Set("Content-Encoding", "br")
+				return ctx.Response.PlainText("brotli", http.StatusOK)
+			})
+
+			srv.Serve("GET", "/precompressed", compress, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				var buf bytes.Buffer
+				w := gzip.NewWriter(&buf)
+				w.Write([]byte("cached content"))
+				w.Close()
+
+				return ctx.Response.Precompressed(srvPkg.EncodingGzip, http.StatusOK, buf.Bytes())
+			})
+
+			ts.Config.Handler = srv.Router
+		})
+
+		AfterEach(func() {
+			ts.Close()
+		})
+
+		get := func(path, acceptEncoding string) (*http.Response, string) {
+			req := test.Get(ts.URL + path)
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+			return test.ProcessRequest(req)
+		}
+
+		It("should compress text responses", func() {
+			res, body := get("/text", "gzip")
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(res.Header.Get("Content-Encoding")).To(Equal(srvPkg.EncodingGzip))
+			Expect(res.Header.Get("Vary")).To(Equal("Accept-Encoding"))
+
+			r, err := gzip.NewReader(bytes.NewBufferString(body))
+			Expect(err).To(BeNil())
+			content, err := ioutil.ReadAll(r)
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(Equal("hello world"))
+		})
+
+		It("should not compress if the client does not accept it", func() {
+			res, body := get("/text", "identity")
+
+			Expect(res.Header.Get("Content-Encoding")).To(BeEmpty())
+			Expect(res.Header.Get("Vary")).To(Equal("Accept-Encoding"))
+			Expect(body).To(Equal("hello world"))
+		})
+
+		It("should not compress for missing or unsupported encodings", func() {
+			for _, acceptEncoding := range []string{"", "br", "br, compress"} {
+				res, body := get("/text", acceptEncoding)
+
+				Expect(res.StatusCode).To(Equal(http.StatusCreated), acceptEncoding)
+				Expect(res.Header.Get("Content-Encoding")).To(BeEmpty())
+				Expect(res.Header.Get("Vary")).To(Equal("Accept-Encoding"), acceptEncoding)
+				Expect(body).To(Equal("hello world"))
+			}
+		})
+
+		It("should respond 406 if identity is rejected without a supported alternative", func() {
+			for _, acceptEncoding := range []string{"identity;q=0", "br, identity;q=0", "gzip;q=0, *;q=0"} {
+				res, _ := get("/text", acceptEncoding)
+
+				Expect(res.StatusCode).To(Equal(http.StatusNotAcceptable), acceptEncoding)
+			}
+		})
+
+		It("should compress if identity is rejected with a supported alternative", func() {
+			res, _ := get("/text", "gzip, identity;q=0")
+
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(res.Header.Get("Content-Encoding")).To(Equal(srvPkg.EncodingGzip))
+		})
+
+		It("should skip explicitly set content types", func() {
+			res, body := get("/image", "gzip")
+
+			Expect(res.Header.Get("Content-Encoding")).To(BeEmpty())
+			Expect(res.Header.Get("Vary")).To(BeEmpty())
+			Expect(body).To(Equal("not really a png"))
+		})
+
+		It("should skip sniffed content types", func() {
+			res, body := get("/sniffed", "gzip")
+
+			Expect(res.Header.Get("Content-Encoding")).To(BeEmpty())
+			Expect(body).To(Equal("GIF89a..."))
+		})
+
+		It("should not append errors to a started compressed body", func() {
+			srv.SetPartialResponsePolicy(srvPkg.PartialResponseAppendError)
+			_, body := get("/partial", "gzip")
+
+			r, err := gzip.NewReader(bytes.NewBufferString(body))
+			Expect(err).To(BeNil())
+			content, err := ioutil.ReadAll(r)
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(Equal("partial"))
+		})
+
+		It("should not count client disconnects as errors", func() {
+			req := test.Get(ts.URL + "/stream")
+			req.Header.Set("Accept-Encoding", "gzip")
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+			Expect(res.Body.Close()).To(Succeed())
+
+			var writeErr error
+			Eventually(streamErr, 10*time.Second).Should(Receive(&writeErr))
+			Expect(writeErr).NotTo(BeNil())
+
+			_, body, _ := test.NewGetRequest(ts.URL + "/debug/vars")
+			vars := map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(body), &vars)).To(Succeed())
+			Expect(vars["server"]).To(HaveKeyWithValue("errors", float64(0)))
+		})
+
+		It("should not compress precompressed responses again", func() {
+			res, body := get("/precompressed", "gzip")
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header["Content-Encoding"]).To(Equal([]string{srvPkg.EncodingGzip}))
+			Expect(res.Header.Get("Content-Length")).To(Equal(strconv.Itoa(len(body))))
+
+			r, err := gzip.NewReader(bytes.NewBufferString(body))
+			Expect(err).To(BeNil())
+			content, err := ioutil.ReadAll(r)
+			Expect(err).To(BeNil())
+			Expect(string(content)).To(Equal("cached content"))
+		})
+
+		It("should not touch already encoded responses", func() {
+			res, body := get("/encoded", "gzip")
+
+			Expect(res.Header.Get("Content-Encoding")).To(Equal("br"))
+			Expect(body).To(Equal("brotli"))
+		})
+	})
+})
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+// ServeReader responds the content of rs using http.ServeContent, for content
+// not stored on the local filesystem, e.g. objects streamed from a storage
+// service. The Content-Type is derived from the extension of name, or sniffed
+// from the content. Range and conditional requests are answered, and the
+// Last-Modified header is set, unless modtime is zero. If size is not
+// negative, it is used as the length of the content, and rs only has to seek
+// when a range is requested.
+// Example: return ctx.ServeReader("report.pdf", object.Modified, object.Size, object.Body)
+func (c *Context) ServeReader(name string, modtime time.Time, size int64, rs io.ReadSeeker) error {
+	if size >= 0 {
+		rs = &sizedReadSeeker{rs: rs, size: size}
+	}
+
+	http.ServeContent(c.recorder, c.req, name, modtime, rs)
+
+	return nil
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// sizedReadSeeker is an io.ReadSeeker of a known size. Seeking only moves the
+// position, the underlying reader is seeked lazily on the next read. The
+// underlying reader is expected at the start, so reading the whole content
+// never seeks it.
+type sizedReadSeeker struct {
+	rs    io.ReadSeeker
+	size  int64
+	pos   int64
+	under int64
+}
+
+func (s *sizedReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errgo.Newf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errgo.Newf("negative position %d", offset)
+	}
+
+	s.pos = offset
+
+	return s.pos, nil
+}
+
+func (s *sizedReadSeeker) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+
+	if s.pos != s.under {
+		under, err := s.rs.Seek(s.pos, io.SeekStart)
+		if err != nil {
+			return 0, errgo.Mask(err)
+		}
+		s.under = under
+	}
+
+	if remaining := s.size - s.pos; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := s.rs.Read(p)
+	s.pos += int64(n)
+	s.under += int64(n)
+
+	return n, err
+}
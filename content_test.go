@@ -0,0 +1,119 @@
+package server_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// endlessSeeker fails when seeking to the end, like a stream whose length is
+// unknown locally.
+type endlessSeeker struct {
+	*strings.Reader
+}
+
+func (s endlessSeeker) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekEnd {
+		return 0, errors.New("cannot seek to the end")
+	}
+	return s.Reader.Seek(offset, whence)
+}
+
+// unseekable fails on every seek, like a plain stream.
+type unseekable struct {
+	io.Reader
+}
+
+func (unseekable) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New("cannot seek")
+}
+
+var _ = Describe("ServeReader", func() {
+	var (
+		ts      *httptest.Server
+		modtime time.Time
+	)
+
+	BeforeEach(func() {
+		logger := requestcontext.MustGetLogger(requestcontext.LoggerConfig{
+			Name:  "test",
+			Level: "critical",
+		})
+
+		srv := srvPkg.NewServer("", "")
+		srv.SetLogger(logger)
+
+		modtime = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		srv.Serve("GET", "/files/report.txt", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.ServeReader("report.txt", modtime, 11, endlessSeeker{strings.NewReader("hello world")})
+		})
+		srv.Serve("GET", "/files/stream.txt", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.ServeReader("stream.txt", time.Time{}, 5, unseekable{strings.NewReader("hello")})
+		})
+		srv.Serve("GET", "/files/unknown", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.ServeReader("unknown", time.Time{}, -1, strings.NewReader("<html><body>hello</body></html>"))
+		})
+
+		ts = test.NewServer(nil)
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("should respond the content with headers", func() {
+		code, body, res := test.NewGetRequest(ts.URL + "/files/report.txt")
+
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("hello world"))
+		Expect(res.Header.Get("Content-Type")).To(Equal("text/plain; charset=utf-8"))
+		Expect(res.Header.Get("Content-Length")).To(Equal("11"))
+		Expect(res.Header.Get("Last-Modified")).To(Equal("Mon, 01 Jun 2020 12:00:00 GMT"))
+	})
+
+	It("should not seek a reader of known size without a range", func() {
+		code, body, res := test.NewGetRequest(ts.URL + "/files/stream.txt")
+
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("hello"))
+		Expect(res.Header.Get("Content-Length")).To(Equal("5"))
+	})
+
+	It("should respond a requested range", func() {
+		req := test.Get(ts.URL + "/files/report.txt")
+		req.Header.Set("Range", "bytes=6-")
+		res, body := test.ProcessRequest(req)
+
+		Expect(res.StatusCode).To(Equal(http.StatusPartialContent))
+		Expect(body).To(Equal("world"))
+		Expect(res.Header.Get("Content-Range")).To(Equal("bytes 6-10/11"))
+	})
+
+	It("should answer conditional requests", func() {
+		req := test.Get(ts.URL + "/files/report.txt")
+		req.Header.Set("If-Modified-Since", modtime.Format(http.TimeFormat))
+		res, _ := test.ProcessRequest(req)
+
+		Expect(res.StatusCode).To(Equal(http.StatusNotModified))
+	})
+
+	It("should sniff the content type and size if unknown", func() {
+		code, _, res := test.NewGetRequest(ts.URL + "/files/unknown")
+
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(res.Header.Get("Content-Type")).To(Equal("text/html; charset=utf-8"))
+		Expect(res.Header.Get("Content-Length")).To(Equal("31"))
+	})
+})
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/juju/errgo"
+)
+
+// NewVerifyContentLengthMiddleware provides a middleware that verifies the
+// number of bytes read from the request body against the Content-Length
+// header. If the body ends early, e.g. because of a truncated upload, or
+// turns out to be longer, reading it returns an error with the cause
+// InvalidBodyError. Even if a following middleware masks that error, an error
+// with the cause InvalidBodyError is returned, which responds with
+// http.StatusBadRequest, as long as the response was not written yet.
+// Requests without a Content-Length, e.g. using chunked transfer encoding, are
+// not checked.
+func NewVerifyContentLengthMiddleware() Middleware {
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		if req.ContentLength < 0 || req.Body == nil || req.Body == http.NoBody {
+			return ctx.Proceed()
+		}
+
+		body := &lengthReader{ReadCloser: req.Body, declared: req.ContentLength}
+		req.Body = body
+
+		err := ctx.Proceed()
+
+		if body.err != nil && !ctx.ResponseWritten() {
+			return body.err
+		}
+
+		return err
+	}
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// lengthReader is a request body that fails if its length differs from the
+// declared one.
+type lengthReader struct {
+	io.ReadCloser
+	declared int64
+	read     int64
+	err      error
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+
+	n, err := l.ReadCloser.Read(p)
+	l.read += int64(n)
+
+	switch {
+	case l.read > l.declared:
+		l.err = errgo.WithCausef(nil, InvalidBodyError, "invalid body: longer than the Content-Length of %d bytes", l.declared)
+	case err == io.ErrUnexpectedEOF || (err == io.EOF && l.read < l.declared):
+		l.err = errgo.WithCausef(nil, InvalidBodyError, "invalid body: got %d of %d bytes", l.read, l.declared)
+	default:
+		return n, err
+	}
+
+	return n, l.err
+}
@@ -0,0 +1,78 @@
+package server_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/giantswarm/request-context"
+	"github.com/juju/errgo"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("verify content length middleware", func() {
+	var srv *srvPkg.Server
+
+	upload := func(body string, contentLength int64) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/upload", strings.NewReader(body))
+		Expect(err).To(BeNil())
+		req.ContentLength = contentLength
+
+		res := httptest.NewRecorder()
+		srv.Router.ServeHTTP(res, req)
+
+		return res
+	}
+
+	BeforeEach(func() {
+		logger := requestcontext.MustGetLogger(requestcontext.LoggerConfig{
+			Name:  "test",
+			Level: "critical",
+		})
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(logger)
+
+		srv.Serve("POST", "/upload", srvPkg.NewVerifyContentLengthMiddleware(), func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			content, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				return errgo.Mask(err)
+			}
+
+			return ctx.Response.PlainText(string(content), http.StatusOK)
+		})
+	})
+
+	It("should pass bodies matching the Content-Length", func() {
+		res := upload("hello world", 11)
+
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(Equal("hello world"))
+	})
+
+	It("should reject truncated bodies", func() {
+		res := upload("hello", 11)
+
+		Expect(res.Code).To(Equal(http.StatusBadRequest))
+		Expect(res.Body.String()).To(ContainSubstring("got 5 of 11 bytes"))
+	})
+
+	It("should reject bodies longer than the Content-Length", func() {
+		res := upload("hello world", 5)
+
+		Expect(res.Code).To(Equal(http.StatusBadRequest))
+		Expect(res.Body.String()).To(ContainSubstring("longer than the Content-Length of 5 bytes"))
+	})
+
+	It("should skip the check without Content-Length", func() {
+		res := upload("hello world", -1)
+
+		Expect(res.Code).To(Equal(http.StatusOK))
+		Expect(res.Body.String()).To(Equal("hello world"))
+	})
+})
@@ -0,0 +1,481 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/request-context"
+	"github.com/gorilla/mux"
+	"github.com/juju/errgo"
+)
+
+// ParseQuery parses the query of the current request and stores the result
+// in `c.Query`. In contrast to `req.URL.Query()`, which silently drops
+// malformed pairs, an error with the cause InvalidQueryError is returned when
+// the query is not properly encoded. Returning this error from a middleware
+// responds with http.StatusBadRequest.
+func (c *Context) ParseQuery() error {
+	query, err := url.ParseQuery(c.req.URL.RawQuery)
+	if err != nil {
+		return errgo.WithCausef(err, InvalidQueryError, "invalid query")
+	}
+
+	c.Query = query
+
+	return nil
+}
+
+// Fail responds with the given status code and message and stops the
+// middleware chain. The response is rendered by the error handler set using
+// SetErrorHandler, if any. In contrast to returning an error, nothing is
+// logged. The returned error must be returned by the middleware as is, so the
+// middleware handler knows the response was already written. If the response
+// was written before, nothing is written and the message is returned as an
+// error, which is handled according to the partial response policy, see
+// SetPartialResponsePolicy.
+//
+//	if !authorized {
+//	  return ctx.Fail(http.StatusForbidden, "access denied")
+//	}
+func (c *Context) Fail(code int, message string) error {
+	if c.ResponseWritten() {
+		return errgo.New(message)
+	}
+
+	if c.server.errorHandler != nil {
+		c.server.callErrorHandler(c, errgo.New(message), code)
+	} else {
+		c.respondError(message, code)
+	}
+
+	return handledError
+}
+
+// RouteMeta returns the metadata attached to the current route using
+// ServeWithMeta. The returned map is never nil.
+func (c *Context) RouteMeta() RouteMeta {
+	return c.routeMeta
+}
+
+// ClientIP returns the IP address of the client that sent the current
+// request. When the server is configured to trust n proxy hops, see
+// SetTrustedProxyHops, the address is taken from the X-Forwarded-For header.
+// The remote address of the connection is the last hop, the n-th entry of the
+// header counted from the right is the client. Entries further left may be
+// spoofed by the client and are ignored.
+func (c *Context) ClientIP() string {
+	return c.server.clientIP(c.req)
+}
+
+// AbsoluteURL returns an absolute URL for the given path, using the scheme and
+// host the client sent the current request to. When the server is configured
+// to trust proxy hops, see SetTrustedProxyHops, the X-Forwarded-Proto and
+// X-Forwarded-Host headers are honored, if each trusted proxy added a value.
+// Otherwise the scheme depends on the connection being TLS and the host is
+// taken from the request. The path is
+// a route path, the base path set using SetBasePath is prepended.
+// Example: ctx.AbsoluteURL("/v1/users?page=2")
+func (c *Context) AbsoluteURL(path string) string {
+	scheme := "http"
+	if c.req.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.forwardedValue("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.ToLower(proto)
+	}
+
+	host := c.req.Host
+	if forwarded := c.forwardedValue("X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if base := strings.Trim(c.server.basePath, "/"); base != "" {
+		path = "/" + base + path
+	}
+
+	return scheme + "://" + host + path
+}
+
+// DefaultRedactedHeaders lists the request headers always redacted by
+// HeadersRedacted.
+var DefaultRedactedHeaders = []string{"Authorization", "Cookie"}
+
+// HeadersRedacted returns a snapshot of the request headers suitable for
+// logging. Multiple values of a header are joined by ", ". The values of the
+// given headers and of DefaultRedactedHeaders are replaced by "***".
+func (c *Context) HeadersRedacted(redact ...string) map[string]string {
+	redacted := map[string]bool{}
+	for _, name := range append(append([]string{}, DefaultRedactedHeaders...), redact...) {
+		redacted[http.CanonicalHeaderKey(name)] = true
+	}
+
+	headers := make(map[string]string, len(c.req.Header))
+	for name, values := range c.req.Header {
+		if redacted[http.CanonicalHeaderKey(name)] {
+			headers[name] = "***"
+			continue
+		}
+
+		headers[name] = strings.Join(values, ", ")
+	}
+
+	return headers
+}
+
+// GetApp returns `c.App`. If the server was configured using
+// SetLazyAppContext, the CtxConstructor is called on the first call to
+// provide the value.
+func (c *Context) GetApp() interface{} {
+	if c.App == nil && c.server.lazyAppContext && c.server.ctxConstructor != nil {
+		c.App = c.server.ctxConstructor()
+	}
+
+	return c.App
+}
+
+// BytesIn returns the number of bytes read from the request body so far.
+func (c *Context) BytesIn() int64 {
+	return c.body.size
+}
+
+// BytesOut returns the number of bytes of the response body written so far.
+func (c *Context) BytesOut() int64 {
+	return c.recorder.size
+}
+
+// ResponseWritten returns true if a status code or any part of the response
+// body was written already. Middlewares running after others should check
+// this before writing a response of their own.
+func (c *Context) ResponseWritten() bool {
+	return c.recorder.written
+}
+
+// Defer registers a function that is called once the middleware chain
+// finished and the response was written, even if a middleware returned an
+// error. Deferred functions are called in reverse order, like defer
+// statements. This is the place to read final values like BytesOut().
+func (c *Context) Defer(f func()) {
+	c.DeferErr(func() error {
+		f()
+		return nil
+	})
+}
+
+// DeferErr registers a function like Defer, which may fail, e.g. when
+// committing a transaction. The errors of all deferred functions are
+// aggregated and handled like an error returned by a middleware. Since the
+// response was usually written at this point, the partial response policy
+// applies, which means the error is logged by default.
+func (c *Context) DeferErr(f func() error) {
+	c.defers = append(c.defers, f)
+}
+
+// runDefers calls the deferred functions in reverse order and returns their
+// aggregated errors, if any.
+func (c *Context) runDefers() error {
+	var errs deferErrors
+	for i := len(c.defers) - 1; i >= 0; i-- {
+		if err := c.defers[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+
+	return errs
+}
+
+// Value returns the value associated with the given key in the context of the
+// current request, e.g. a value set by an outer http.Handler wrapping the
+// server's Handler().
+func (c *Context) Value(key interface{}) interface{} {
+	return c.req.Context().Value(key)
+}
+
+// SetTimeout limits the time the remaining middlewares may take to process the
+// current request. The request passed to subsequent middlewares carries a
+// context that is canceled once the timeout elapsed. Calls made using this
+// context, e.g. upstream requests, are aborted then. Middlewares are expected
+// to check `req.Context()` themselves, the response is not aborted.
+func (c *Context) SetTimeout(d time.Duration) {
+	timeoutCtx, cancel := context.WithTimeout(c.req.Context(), d)
+	c.req = c.req.WithContext(timeoutCtx)
+	c.Defer(cancel)
+}
+
+// Deadline returns the time the current request must be processed by, as
+// set by SetTimeout or by the context of the request. False is returned if
+// the request has no deadline.
+func (c *Context) Deadline() (time.Time, bool) {
+	return c.req.Context().Deadline()
+}
+
+// TimeRemaining returns the time left until the deadline of the current
+// request, which allows to e.g. skip optional work when not much time is
+// left. A negative duration is returned once the deadline passed. If the
+// request has no deadline, the maximum duration is returned.
+func (c *Context) TimeRemaining() time.Duration {
+	deadline, ok := c.Deadline()
+	if !ok {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Until(deadline)
+}
+
+// ErrorMiddleware returns the name of the middleware that first returned an
+// error while processing the current request, or an empty string if none did.
+// See NamedMiddleware.
+func (c *Context) ErrorMiddleware() string {
+	if c.failed < 0 {
+		return ""
+	}
+
+	return c.middlewareName(c.failed)
+}
+
+// Debugf logs a debug message using the logger of the server. The request
+// context, including the request ID and the route, and the method and URL of
+// the current request are attached. Nothing is logged if the server has no
+// logger.
+func (c *Context) Debugf(format string, args ...interface{}) {
+	if logger, ok := c.logger(); ok {
+		logger.Debug(c.logContext(), "%s %s "+format, c.logArgs(args)...)
+	}
+}
+
+// Infof logs an info message like Debugf.
+func (c *Context) Infof(format string, args ...interface{}) {
+	if logger, ok := c.logger(); ok {
+		logger.Info(c.logContext(), "%s %s "+format, c.logArgs(args)...)
+	}
+}
+
+// Errorf logs an error message like Debugf.
+func (c *Context) Errorf(format string, args ...interface{}) {
+	if logger, ok := c.logger(); ok {
+		logger.Error(c.logContext(), "%s %s "+format, c.logArgs(args)...)
+	}
+}
+
+// Go runs f in a new goroutine. A panic in f is recovered and logged, instead
+// of crashing the whole process. Use it for background work spawned by a
+// middleware. Note that f runs independently of the request. Neither its
+// outcome nor a panic affects the response, which might be sent already.
+func (c *Context) Go(f func()) {
+	requestCtx := requestcontext.Ctx{}
+	for k, v := range c.Request {
+		requestCtx[k] = v
+	}
+	method, url := c.req.Method, c.req.URL.String()
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				c.server.Logger.Error(requestCtx, "%s %s goroutine panicked: %v\n%s", method, url, r, debug.Stack())
+			}
+		}()
+
+		f()
+	}()
+}
+
+// Proceed executes the remaining middlewares of the chain right away and
+// returns the error one of them returned, if any. This allows a middleware to
+// wrap the downstream chain, e.g. to act on its errors or on the status code
+// of the response. Errors returned by Proceed are not yet responded. It is up
+// to the calling middleware to return them, or to handle them itself. Once
+// Proceed returned, the remaining middlewares are not executed again.
+//
+//	func(res http.ResponseWriter, req *http.Request, ctx *server.Context) error {
+//		start := time.Now()
+//		err := ctx.Proceed()
+//		log.Printf("took %s", time.Since(start))
+//		return err
+//	}
+func (c *Context) Proceed() error {
+	return c.runChain()
+}
+
+// runChain calls the remaining middlewares in order, as long as each of them
+// calls Next() and returns no error.
+func (c *Context) runChain() error {
+	for c.next < len(c.middlewares) {
+		i := c.next
+		middleware := c.middlewares[i]
+		c.next++
+		c.running = i
+
+		nextCalled := false
+		c.Next = func() error {
+			nextCalled = true
+			return nil
+		}
+
+		var err error
+		if c.server.middlewareTiming {
+			err = c.timeMiddleware(i, middleware)
+		} else {
+			err = middleware(c.recorder, c.req, c)
+		}
+
+		if err != nil {
+			if c.failed < 0 {
+				c.failed = i
+			}
+
+			c.next = len(c.middlewares)
+			return err
+		}
+
+		if !nextCalled {
+			c.next = len(c.middlewares)
+		}
+	}
+
+	return nil
+}
+
+// respondError responds with the given plain text error message. The
+// Content-Type is replaced, so a type set for the route or by a middleware
+// does not mislabel the message.
+func (c *Context) respondError(message string, code int) {
+	c.recorder.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	c.Response.Error(message, code)
+}
+
+// logger returns the logger of the server, if one is configured.
+func (c *Context) logger() (requestcontext.Logger, bool) {
+	if c.server == nil || c.server.Logger == (requestcontext.Logger{}) {
+		return requestcontext.Logger{}, false
+	}
+
+	return c.server.Logger, true
+}
+
+// logContext returns a copy of the request context with the path template of
+// the matched route added. The request context itself is reported in the
+// access log, which gets the route attached separately.
+func (c *Context) logContext() requestcontext.Ctx {
+	logCtx := requestcontext.Ctx{}
+	for k, v := range c.Request {
+		logCtx[k] = v
+	}
+
+	if route := mux.CurrentRoute(c.req); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			logCtx["route"] = template
+		}
+	}
+
+	return logCtx
+}
+
+// logArgs prepends the method and URL of the current request to the given
+// format arguments.
+func (c *Context) logArgs(args []interface{}) []interface{} {
+	return append([]interface{}{c.req.Method, c.req.URL}, args...)
+}
+
+// timeMiddleware executes the i-th middleware and records the time spent in
+// it, excluding the time spent in the middlewares it called using Proceed.
+func (c *Context) timeMiddleware(i int, middleware Middleware) error {
+	outer := c.calleeDuration
+	c.calleeDuration = 0
+
+	// Reserve the slot, to keep the timings in order of execution.
+	slot := len(c.middlewareTimings)
+	c.middlewareTimings = append(c.middlewareTimings, Timing{})
+
+	start := time.Now()
+	err := middleware(c.recorder, c.req, c)
+	d := time.Since(start)
+
+	c.middlewareTimings[slot] = Timing{Name: c.middlewareName(i), Duration: d - c.calleeDuration}
+	c.calleeDuration = outer + d
+
+	return err
+}
+
+// clientIP returns the IP address of the client that sent the given request,
+// see ClientIP.
+func (s *Server) clientIP(req *http.Request) string {
+	remoteIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remoteIP = req.RemoteAddr
+	}
+
+	hops := s.trustedProxyHops
+	if hops <= 0 {
+		return remoteIP
+	}
+
+	addrs := []string{}
+	for _, header := range req.Header["X-Forwarded-For"] {
+		for _, addr := range strings.Split(header, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	addrs = append(addrs, remoteIP)
+
+	i := len(addrs) - 1 - hops
+	if i < 0 {
+		// There are less entries than trusted proxies. All of them were added by
+		// trusted proxies, so the leftmost one is the client.
+		i = 0
+	}
+
+	return addrs[i]
+}
+
+// forwardedValue returns the value of the given forwarded header set by the
+// outermost trusted proxy, or an empty string if no proxy hops are trusted.
+// If the header has less values than trusted hops, some proxy did not set it
+// and the leftmost value may be sent by the client, so none is returned.
+func (c *Context) forwardedValue(name string) string {
+	hops := c.server.trustedProxyHops
+	if hops <= 0 {
+		return ""
+	}
+
+	values := []string{}
+	for _, header := range c.req.Header[http.CanonicalHeaderKey(name)] {
+		for _, value := range strings.Split(header, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	if len(values) < hops {
+		return ""
+	}
+
+	return values[len(values)-hops]
+}
+
+// middlewareName returns the name of the i-th middleware of the chain. Unless
+// the middleware was wrapped using NamedMiddleware and already executed, the
+// name of the function is used.
+func (c *Context) middlewareName(i int) string {
+	if name, ok := c.names[i]; ok {
+		return name
+	}
+
+	return funcName(c.middlewares[i])
+}
@@ -0,0 +1,507 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/middleware-server/jsonschema"
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Context", func() {
+	var (
+		ts   *httptest.Server
+		srv  *srvPkg.Server
+		code int
+		body string
+	)
+
+	BeforeEach(func() {
+		ts = test.NewServer(nil)
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	Context("ParseQuery", func() {
+		BeforeEach(func() {
+			srv.Serve("GET", "/query", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				if err := ctx.ParseQuery(); err != nil {
+					return err
+				}
+
+				return ctx.Response.PlainText(ctx.Query.Get("name"), http.StatusOK)
+			})
+		})
+
+		It("should provide the parsed query", func() {
+			code, body, _ = test.NewGetRequest(ts.URL + "/query?name=foo")
+
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("foo"))
+		})
+
+		It("should respond with status code 400 on malformed query", func() {
+			code, body, _ = test.NewGetRequest(ts.URL + "/query?name=%zz")
+
+			Expect(code).To(Equal(http.StatusBadRequest))
+			Expect(body).To(ContainSubstring("invalid query"))
+		})
+	})
+
+	Context("Fail", func() {
+		BeforeEach(func() {
+			fail := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Fail(http.StatusForbidden, "access denied")
+			}
+			ok := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText("OK", http.StatusOK)
+			}
+			srv.Serve("GET", "/fail", fail, ok)
+
+			code, body, _ = test.NewGetRequest(ts.URL + "/fail")
+		})
+
+		It("should respond with the given status code and message only", func() {
+			Expect(code).To(Equal(http.StatusForbidden))
+			Expect(body).To(Equal("access denied"))
+		})
+	})
+
+	Context("ClientIP", func() {
+		request := func(xff string) string {
+			req := test.Get(ts.URL + "/ip")
+			if xff != "" {
+				req.Header.Set("X-Forwarded-For", xff)
+			}
+
+			_, body := test.ProcessRequest(req)
+			return body
+		}
+
+		BeforeEach(func() {
+			srv.Serve("GET", "/ip", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText(ctx.ClientIP(), http.StatusOK)
+			})
+		})
+
+		It("should ignore X-Forwarded-For by default", func() {
+			Expect(request("1.1.1.1")).To(Equal("127.0.0.1"))
+		})
+
+		It("should pick the client address according to the trusted hops", func() {
+			srv.SetTrustedProxyHops(1)
+			Expect(request("9.9.9.9, 1.1.1.1")).To(Equal("1.1.1.1"))
+
+			srv.SetTrustedProxyHops(2)
+			Expect(request("9.9.9.9, 1.1.1.1, 2.2.2.2")).To(Equal("1.1.1.1"))
+		})
+
+		It("should fall back to the leftmost address when there are less hops", func() {
+			srv.SetTrustedProxyHops(3)
+			Expect(request("1.1.1.1")).To(Equal("1.1.1.1"))
+		})
+	})
+
+	Context("AbsoluteURL", func() {
+		request := func(proto, host string) string {
+			req := test.Get(ts.URL + "/url")
+			req.Host = "internal:8000"
+			if proto != "" {
+				req.Header.Set("X-Forwarded-Proto", proto)
+			}
+			if host != "" {
+				req.Header.Set("X-Forwarded-Host", host)
+			}
+
+			_, body := test.ProcessRequest(req)
+			return body
+		}
+
+		BeforeEach(func() {
+			srv.Serve("GET", "/url", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText(ctx.AbsoluteURL("/v1/users?page=2"), http.StatusOK)
+			})
+		})
+
+		It("should use the request host and ignore forwarded headers by default", func() {
+			Expect(request("https", "example.com")).To(Equal("http://internal:8000/v1/users?page=2"))
+		})
+
+		It("should honor forwarded headers of trusted proxies", func() {
+			srv.SetTrustedProxyHops(1)
+			Expect(request("https", "example.com")).To(Equal("https://example.com/v1/users?page=2"))
+			Expect(request("", "")).To(Equal("http://internal:8000/v1/users?page=2"))
+
+			srv.SetTrustedProxyHops(2)
+			Expect(request("https, http", "example.com, internal:8000")).To(Equal("https://example.com/v1/users?page=2"))
+		})
+
+		It("should ignore forwarded headers not set by every trusted proxy", func() {
+			srv.SetTrustedProxyHops(2)
+			Expect(request("https", "attacker.com")).To(Equal("http://internal:8000/v1/users?page=2"))
+		})
+	})
+
+	Context("HeadersRedacted", func() {
+		var headers map[string]string
+
+		BeforeEach(func() {
+			srv.Serve("GET", "/headers", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				headers = ctx.HeadersRedacted("x-api-key")
+				return ctx.Response.NoContent()
+			})
+
+			req := test.Get(ts.URL + "/headers")
+			req.Header.Set("Authorization", "Bearer secret")
+			req.Header.Set("Cookie", "session=secret")
+			req.Header.Set("X-Api-Key", "secret")
+			req.Header.Add("Accept", "text/plain")
+			req.Header.Add("Accept", "application/json")
+			test.ProcessRequest(req)
+		})
+
+		It("should redact default and given headers", func() {
+			Expect(headers).To(HaveKeyWithValue("Authorization", "***"))
+			Expect(headers).To(HaveKeyWithValue("Cookie", "***"))
+			Expect(headers).To(HaveKeyWithValue("X-Api-Key", "***"))
+		})
+
+		It("should join multiple values", func() {
+			Expect(headers).To(HaveKeyWithValue("Accept", "text/plain, application/json"))
+		})
+	})
+
+	Context("BytesIn, BytesOut and Defer", func() {
+		var (
+			bytesIn  int64
+			bytesOut int64
+			order    []string
+		)
+
+		BeforeEach(func() {
+			order = nil
+
+			srv.Serve("POST", "/echo", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				ctx.Defer(func() {
+					order = append(order, "first")
+					bytesIn = ctx.BytesIn()
+					bytesOut = ctx.BytesOut()
+				})
+				ctx.Defer(func() {
+					order = append(order, "second")
+				})
+
+				content, err := ioutil.ReadAll(req.Body)
+				if err != nil {
+					return err
+				}
+
+				return ctx.Response.PlainText(string(content)+string(content), http.StatusOK)
+			})
+
+			code, body, _ = test.NewPostRequest(ts.URL+"/echo", "hello", nil)
+		})
+
+		It("should count the bytes read and written", func() {
+			Expect(body).To(Equal("hellohello"))
+			Expect(bytesIn).To(Equal(int64(5)))
+			Expect(bytesOut).To(Equal(int64(10)))
+		})
+
+		It("should call deferred functions in reverse order", func() {
+			Expect(order).To(Equal([]string{"second", "first"}))
+		})
+	})
+
+	Context("DeferErr", func() {
+		BeforeEach(func() {
+			srv.SetPartialResponsePolicy(srvPkg.PartialResponseAppendError)
+
+			srv.Serve("GET", "/commit", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				ctx.DeferErr(func() error {
+					return errors.New("commit failed")
+				})
+				ctx.DeferErr(func() error {
+					return nil
+				})
+				ctx.DeferErr(func() error {
+					return errors.New("unlock failed")
+				})
+
+				return ctx.Response.PlainText("OK ", http.StatusOK)
+			})
+			srv.Serve("GET", "/unanswered", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				ctx.DeferErr(func() error {
+					return errors.New("commit failed")
+				})
+
+				return nil
+			})
+		})
+
+		It("should handle the aggregated errors after the response was written", func() {
+			code, body, _ = test.NewGetRequest(ts.URL + "/commit")
+
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("OK unlock failed; commit failed"))
+		})
+
+		It("should respond with the error if no response was written", func() {
+			code, body, _ = test.NewGetRequest(ts.URL + "/unanswered")
+
+			Expect(code).To(Equal(http.StatusInternalServerError))
+			Expect(body).To(Equal("commit failed"))
+		})
+	})
+
+	Context("ResponseWritten", func() {
+		var before, after bool
+
+		BeforeEach(func() {
+			fallback := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				if err := ctx.Proceed(); err != nil {
+					return err
+				}
+				if !ctx.ResponseWritten() {
+					return ctx.Response.PlainText("fallback", http.StatusOK)
+				}
+				return nil
+			}
+
+			srv.Serve("GET", "/written", fallback, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				before = ctx.ResponseWritten()
+				err := ctx.Response.NoContent()
+				after = ctx.ResponseWritten()
+				return err
+			})
+			srv.Serve("GET", "/silent", fallback, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return nil
+			})
+		})
+
+		It("should report whether the response was written", func() {
+			code, _, _ = test.NewGetRequest(ts.URL + "/written")
+
+			Expect(code).To(Equal(http.StatusNoContent))
+			Expect(before).To(BeFalse())
+			Expect(after).To(BeTrue())
+		})
+
+		It("should allow after-middlewares to respond if nobody did", func() {
+			code, body, _ = test.NewGetRequest(ts.URL + "/silent")
+
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("fallback"))
+		})
+	})
+
+	Context("DecodeJSONSchema", func() {
+		schema := []byte(`{
+			"type": "object",
+			"required": ["name"],
+			"additionalProperties": false,
+			"properties": {
+				"name": {"type": "string", "minLength": 1},
+				"age": {"type": "integer", "minimum": 0},
+				"tags": {"type": "array", "items": {"enum": ["a", "b"]}}
+			}
+		}`)
+
+		var user struct {
+			Name string
+			Age  int
+		}
+
+		BeforeEach(func() {
+			srv.SetSchemaValidator(jsonschema.Validator{})
+
+			srv.Serve("POST", "/users", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				if err := ctx.DecodeJSONSchema(schema, &user); err != nil {
+					return err
+				}
+				return ctx.Response.NoContent()
+			})
+		})
+
+		It("should decode valid bodies", func() {
+			code, _, _ = test.NewPostRequest(ts.URL+"/users", `{"name": "alice", "age": 42, "tags": ["a"]}`, nil)
+
+			Expect(code).To(Equal(http.StatusNoContent))
+			Expect(user.Name).To(Equal("alice"))
+			Expect(user.Age).To(Equal(42))
+		})
+
+		It("should list the violations", func() {
+			code, body, _ = test.NewPostRequest(ts.URL+"/users", `{"age": 1.5, "tags": ["c"], "admin": true}`, nil)
+
+			Expect(code).To(Equal(http.StatusBadRequest))
+			Expect(body).To(Equal("invalid body: /name: is required; /admin: is not allowed; /age: must be of type integer; /tags/0: must be one of [\"a\",\"b\"]"))
+		})
+
+		It("should reject malformed JSON", func() {
+			code, body, _ = test.NewPostRequest(ts.URL+"/users", `{"name":`, nil)
+
+			Expect(code).To(Equal(http.StatusBadRequest))
+			Expect(body).To(Equal("invalid body: malformed JSON"))
+		})
+
+		It("should reject oversized bodies", func() {
+			large := `{"name": "` + strings.Repeat("a", 4<<20) + `"}`
+			code, body, _ = test.NewPostRequest(ts.URL+"/users", large, nil)
+
+			Expect(code).To(Equal(http.StatusBadRequest))
+			Expect(body).To(Equal("invalid body: exceeds 4194304 bytes"))
+		})
+	})
+
+	Context("Value", func() {
+		type outerKey struct{}
+
+		BeforeEach(func() {
+			srv.Serve("GET", "/value", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				ctx.SetTimeout(time.Second)
+				return ctx.Next()
+			}, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				value, _ := ctx.Value(outerKey{}).(string)
+				return ctx.Response.PlainText(value, http.StatusOK)
+			})
+
+			handler := srv.Handler()
+			ts.Config.Handler = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				handler.ServeHTTP(res, req.WithContext(context.WithValue(req.Context(), outerKey{}, "outer value")))
+			})
+		})
+
+		It("should return values set by outer handlers", func() {
+			code, body, _ = test.NewGetRequest(ts.URL + "/value")
+
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("outer value"))
+		})
+	})
+
+	Context("Deadline and TimeRemaining", func() {
+		var (
+			deadline    time.Time
+			hasDeadline bool
+			remaining   time.Duration
+			reqDeadline bool
+		)
+
+		BeforeEach(func() {
+			handler := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				deadline, hasDeadline = ctx.Deadline()
+				remaining = ctx.TimeRemaining()
+				_, reqDeadline = req.Context().Deadline()
+
+				return ctx.Response.NoContent()
+			}
+			timeout := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				ctx.SetTimeout(time.Minute)
+				return ctx.Next()
+			}
+
+			srv.Serve("GET", "/unlimited", handler)
+			srv.Serve("GET", "/limited", timeout, handler)
+		})
+
+		It("should report no deadline by default", func() {
+			test.NewGetRequest(ts.URL + "/unlimited")
+
+			Expect(hasDeadline).To(BeFalse())
+			Expect(remaining).To(BeNumerically(">", 24*time.Hour))
+		})
+
+		It("should report the deadline set by a previous middleware", func() {
+			test.NewGetRequest(ts.URL + "/limited")
+
+			Expect(hasDeadline).To(BeTrue())
+			Expect(reqDeadline).To(BeTrue())
+			Expect(deadline).To(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+			Expect(remaining).To(BeNumerically("~", time.Minute, time.Second))
+		})
+	})
+
+	Context("NamedMiddleware", func() {
+		var name string
+
+		BeforeEach(func() {
+			name = "not called"
+
+			defers := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				ctx.Defer(func() {
+					name = ctx.ErrorMiddleware()
+				})
+				return ctx.Next()
+			}
+			fail := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return errors.New("test error")
+			}
+			ok := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.NoContent()
+			}
+
+			srv.Serve("GET", "/ok", defers, srvPkg.NamedMiddleware("ok", ok))
+			srv.Serve("GET", "/named", defers, srvPkg.NamedMiddleware("validation", fail))
+			srv.Serve("GET", "/unnamed", defers, fail)
+		})
+
+		It("should report no middleware without errors", func() {
+			test.NewGetRequest(ts.URL + "/ok")
+			Expect(name).To(Equal(""))
+		})
+
+		It("should report the name of the failing middleware", func() {
+			test.NewGetRequest(ts.URL + "/named")
+			Expect(name).To(Equal("validation"))
+		})
+
+		It("should fall back to the function name", func() {
+			test.NewGetRequest(ts.URL + "/unnamed")
+			Expect(name).To(HavePrefix("middleware-server_test."))
+		})
+	})
+
+	Context("Go", func() {
+		var done chan struct{}
+
+		BeforeEach(func() {
+			done = make(chan struct{})
+
+			srv.Serve("GET", "/background", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				ctx.Go(func() {
+					defer close(done)
+					panic("test panic")
+				})
+
+				return ctx.Response.PlainText("OK", http.StatusOK)
+			})
+
+			code, body, _ = test.NewGetRequest(ts.URL + "/background")
+		})
+
+		It("should recover panics of the goroutine", func() {
+			Eventually(done).Should(BeClosed())
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("OK"))
+		})
+	})
+})
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// CORSMetaKey is the route metadata key holding the CORSOptions of a route.
+const CORSMetaKey = "cors"
+
+var DefaultCORSMethods = []string{"GET", "HEAD", "POST"}
+
+type CORSOptions struct {
+	// AllowedOrigins lists the origins allowed to access a route. An origin
+	// may be "*" to allow any origin, or contain a wildcard to allow any
+	// subdomain, e.g. "https://*.example.com".
+	AllowedOrigins []string
+
+	// AllowedMethods lists the methods allowed in preflight requests.
+	AllowedMethods []string
+
+	// AllowedHeaders lists the request headers allowed in preflight requests.
+	// If empty, the headers requested by the client are allowed.
+	AllowedHeaders []string
+
+	// ExposedHeaders lists the response headers clients are allowed to read.
+	ExposedHeaders []string
+
+	// AllowCredentials allows requests carrying cookies or authentication. It
+	// requires explicit origins, the wildcard origin "*" is rejected.
+	AllowCredentials bool
+
+	// MaxAge specifies how many seconds the result of a preflight request may
+	// be cached. Zero omits the Access-Control-Max-Age header.
+	MaxAge int
+}
+
+// NewCORSMiddleware provides a middleware that applies a cross-origin
+// resource sharing policy. It is meant to be registered once using
+// `s.Use()`. The policy of a route is read from its metadata under
+// CORSMetaKey, see ServeWithMeta. Routes without such metadata use the given
+// default policy. Cross-origin requests of origins not allowed by the policy
+// are passed on without CORS headers, so browsers reject them. Preflight
+// requests are answered directly, using the policy of the route of the
+// requested method, even if no OPTIONS route is registered for the path.
+// Options allowing credentials for the wildcard origin cause a panic, here
+// and in ServeWithMeta.
+func NewCORSMiddleware(defaults CORSOptions) Middleware {
+	defaults.validate()
+
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		options := defaults
+		if routeOptions, ok := ctx.RouteMeta()[CORSMetaKey].(CORSOptions); ok {
+			options = routeOptions
+		}
+
+		origin := req.Header.Get("Origin")
+		if origin == "" {
+			return ctx.Next()
+		}
+
+		header := res.Header()
+		header.Add("Vary", "Origin")
+
+		allowOrigin, ok := options.allowOrigin(origin)
+		if !ok {
+			return ctx.Next()
+		}
+
+		header.Set("Access-Control-Allow-Origin", allowOrigin)
+		if options.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		requestMethod := req.Header.Get("Access-Control-Request-Method")
+		if req.Method != "OPTIONS" || requestMethod == "" {
+			if len(options.ExposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(options.ExposedHeaders, ", "))
+			}
+
+			return ctx.Next()
+		}
+
+		// Answer the preflight request.
+		methods := options.AllowedMethods
+		if len(methods) == 0 {
+			methods = DefaultCORSMethods
+		}
+		if containsString(methods, strings.ToUpper(requestMethod)) {
+			header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+			if len(options.AllowedHeaders) > 0 {
+				header.Set("Access-Control-Allow-Headers", strings.Join(options.AllowedHeaders, ", "))
+			} else if requestHeaders := req.Header.Get("Access-Control-Request-Headers"); requestHeaders != "" {
+				header.Set("Access-Control-Allow-Headers", requestHeaders)
+			}
+
+			if options.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(options.MaxAge))
+			}
+		}
+
+		return ctx.Response.NoContent()
+	}
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// preflightMeta returns the metadata of the route a CORS preflight request
+// asks for, and false if the request is no preflight request or no route
+// registered using ServeWithMeta matches the requested method.
+func (s *Server) preflightMeta(req *http.Request) (RouteMeta, bool) {
+	requestMethod := req.Header.Get("Access-Control-Request-Method")
+	if req.Method != "OPTIONS" || requestMethod == "" || req.Header.Get("Origin") == "" {
+		return nil, false
+	}
+
+	preflight := req.WithContext(req.Context())
+	preflight.Method = strings.ToUpper(requestMethod)
+
+	var match mux.RouteMatch
+	if !s.Router.Match(preflight, &match) || match.MatchErr != nil {
+		return nil, false
+	}
+
+	meta, ok := s.routeMeta[match.Route]
+	return meta, ok
+}
+
+// validate panics if the options allow credentials for any origin, which
+// would let any website make authenticated requests and read the responses.
+func (o CORSOptions) validate() {
+	if o.AllowCredentials && containsString(o.AllowedOrigins, "*") {
+		panic("Invalid CORS options allowing credentials for the wildcard origin. Aborting...")
+	}
+}
+
+// allowOrigin returns the value of the Access-Control-Allow-Origin header for
+// the given origin, and false if the origin is not allowed.
+func (o CORSOptions) allowOrigin(origin string) (string, bool) {
+	for _, allowed := range o.AllowedOrigins {
+		if allowed == "*" {
+			return "*", true
+		}
+
+		if matchOrigin(allowed, origin) {
+			return origin, true
+		}
+	}
+
+	return "", false
+}
+
+// matchOrigin checks the origin against the given pattern. A wildcard in the
+// pattern matches one or more subdomain labels.
+func matchOrigin(pattern, origin string) bool {
+	pattern = strings.ToLower(pattern)
+	origin = strings.ToLower(origin)
+
+	i := strings.Index(pattern, "*")
+	if i < 0 {
+		return pattern == origin
+	}
+
+	prefix, suffix := pattern[:i], pattern[i+1:]
+
+	if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+
+	// Make sure the wildcard only matches subdomain labels.
+	subdomain := origin[len(prefix) : len(origin)-len(suffix)]
+
+	return !strings.ContainsAny(subdomain, "/:@")
+}
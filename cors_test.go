@@ -0,0 +1,155 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CORS middleware", func() {
+	var (
+		ts  *httptest.Server
+		srv *srvPkg.Server
+	)
+
+	request := func(method, path, origin string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		Expect(err).To(BeNil())
+		req.Header.Set("Origin", origin)
+		if method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "PUT")
+		}
+
+		res, _ := test.ProcessRequest(req)
+		return res
+	}
+
+	// preflight sends a preflight request for a GET request with a custom
+	// header.
+	preflight := func(path, origin string) *http.Response {
+		req, err := http.NewRequest("OPTIONS", ts.URL+path, nil)
+		Expect(err).To(BeNil())
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		req.Header.Set("Access-Control-Request-Headers", "Authorization")
+
+		res, _ := test.ProcessRequest(req)
+		return res
+	}
+
+	BeforeEach(func() {
+		ts = test.NewServer(nil)
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		srv.Use(srvPkg.NewCORSMiddleware(srvPkg.CORSOptions{
+			AllowedOrigins: []string{"https://default.com"},
+		}))
+
+		ok := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.Response.PlainText("OK", http.StatusOK)
+		}
+		publicMeta := srvPkg.RouteMeta{
+			srvPkg.CORSMetaKey: srvPkg.CORSOptions{
+				AllowedOrigins: []string{"*"},
+			},
+		}
+		adminMeta := srvPkg.RouteMeta{
+			srvPkg.CORSMetaKey: srvPkg.CORSOptions{
+				AllowedOrigins:   []string{"https://*.admin.com"},
+				AllowedMethods:   []string{"GET", "PUT"},
+				AllowCredentials: true,
+				MaxAge:           600,
+			},
+		}
+
+		srv.Serve("GET", "/default", ok)
+		srv.ServeWithMeta("GET", "/public", publicMeta, ok)
+		srv.ServeWithMeta("GET", "/admin", adminMeta, ok)
+		srv.ServeWithMeta("OPTIONS", "/admin", adminMeta, ok)
+		srv.ServeWithMeta("GET", "/admin/users", adminMeta, ok)
+
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("should apply the default policy to routes without metadata", func() {
+		Expect(request("GET", "/default", "https://default.com").Header.Get("Access-Control-Allow-Origin")).To(Equal("https://default.com"))
+		Expect(request("GET", "/default", "https://other.com").Header.Get("Access-Control-Allow-Origin")).To(Equal(""))
+	})
+
+	It("should apply the policy of the public route", func() {
+		Expect(request("GET", "/public", "https://other.com").Header.Get("Access-Control-Allow-Origin")).To(Equal("*"))
+	})
+
+	It("should apply the policy of the admin route", func() {
+		res := request("GET", "/admin", "https://eu.admin.com")
+		Expect(res.Header.Get("Access-Control-Allow-Origin")).To(Equal("https://eu.admin.com"))
+		Expect(res.Header.Get("Access-Control-Allow-Credentials")).To(Equal("true"))
+
+		Expect(request("GET", "/admin", "https://other.com").Header.Get("Access-Control-Allow-Origin")).To(Equal(""))
+		Expect(request("GET", "/admin", "https://admin.com").Header.Get("Access-Control-Allow-Origin")).To(Equal(""))
+	})
+
+	It("should reject credentials for the wildcard origin", func() {
+		options := srvPkg.CORSOptions{
+			AllowedOrigins:   []string{"*"},
+			AllowCredentials: true,
+		}
+
+		Expect(func() { srvPkg.NewCORSMiddleware(options) }).To(Panic())
+		Expect(func() {
+			srv.ServeWithMeta("GET", "/credentials", srvPkg.RouteMeta{srvPkg.CORSMetaKey: options}, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return nil
+			})
+		}).To(Panic())
+	})
+
+	It("should answer preflight requests", func() {
+		res := request("OPTIONS", "/admin", "https://eu.admin.com")
+		Expect(res.StatusCode).To(Equal(http.StatusNoContent))
+		Expect(res.Header.Get("Access-Control-Allow-Methods")).To(Equal("GET, PUT"))
+		Expect(res.Header.Get("Access-Control-Max-Age")).To(Equal("600"))
+	})
+
+	It("should answer preflight requests of routes registered only for GET", func() {
+		res := preflight("/admin/users", "https://eu.admin.com")
+		Expect(res.StatusCode).To(Equal(http.StatusNoContent))
+		Expect(res.Header.Get("Access-Control-Allow-Origin")).To(Equal("https://eu.admin.com"))
+		Expect(res.Header.Get("Access-Control-Allow-Methods")).To(Equal("GET, PUT"))
+		Expect(res.Header.Get("Access-Control-Allow-Headers")).To(Equal("Authorization"))
+		Expect(res.Header.Get("Access-Control-Max-Age")).To(Equal("600"))
+
+		res = preflight("/default", "https://default.com")
+		Expect(res.StatusCode).To(Equal(http.StatusNoContent))
+		Expect(res.Header.Get("Access-Control-Allow-Origin")).To(Equal("https://default.com"))
+	})
+
+	It("should not answer preflight requests for methods without a route", func() {
+		Expect(request("OPTIONS", "/admin/users", "https://eu.admin.com").StatusCode).To(Equal(http.StatusMethodNotAllowed))
+	})
+
+	It("should not allow preflight requests of origins not allowed by the route", func() {
+		res := preflight("/admin/users", "https://other.com")
+		Expect(res.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+		Expect(res.Header.Get("Access-Control-Allow-Origin")).To(Equal(""))
+	})
+
+	It("should not answer OPTIONS requests other than preflight requests", func() {
+		req, err := http.NewRequest("OPTIONS", ts.URL+"/admin/users", nil)
+		Expect(err).To(BeNil())
+		res, _ := test.ProcessRequest(req)
+		Expect(res.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
@@ -0,0 +1,83 @@
+package server_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("context logging", func() {
+	var output string
+
+	BeforeEach(func() {
+		ts := test.NewServer(nil)
+		logs := make(chan string, 1)
+
+		// The logger writes to the os.Stderr it was created with.
+		r, w, err := os.Pipe()
+		Expect(err).To(BeNil())
+		stderr := os.Stderr
+		os.Stderr = w
+		logger := requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test-context-logging", Level: "info"})
+		os.Stderr = stderr
+
+		go func() {
+			var buf bytes.Buffer
+			io.Copy(&buf, r)
+			logs <- buf.String()
+		}()
+
+		srv := srvPkg.NewServer("", "")
+		srv.SetLogger(logger)
+		srv.SetAccessReporter(func(ctx requestcontext.Ctx, logger requestcontext.Logger) srvPkg.AccessReporter {
+			return func(entry *srvPkg.AccessEntry) {}
+		})
+
+		srv.Serve("GET", "/v1/users/{id}", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			ctx.SetRequestID("test-id")
+			ctx.Debugf("not logged at level info")
+			ctx.Infof("loading user %s", ctx.MuxVars["id"])
+			ctx.Errorf("cannot load user: %s", "timeout")
+			return ctx.Response.NoContent()
+		})
+		srv.Serve("GET", "/v1/silent", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			srv.SetLogger(requestcontext.Logger{})
+			ctx.Infof("not logged without logger")
+			return ctx.Response.NoContent()
+		})
+
+		ts.Config.Handler = srv.Router
+
+		code, _, _ := test.NewGetRequest(ts.URL + "/v1/users/42")
+		Expect(code).To(Equal(http.StatusNoContent))
+		code, _, _ = test.NewGetRequest(ts.URL + "/v1/silent")
+		Expect(code).To(Equal(http.StatusNoContent))
+
+		ts.Close()
+		w.Close()
+
+		Eventually(logs).Should(Receive(&output))
+	})
+
+	It("should attach the request, its ID and the route", func() {
+		Expect(output).To(ContainSubstring(`| INFO | GET /v1/users/42 loading user 42 | {"request-id":"test-id","route":"/v1/users/{id}"}`))
+		Expect(output).To(ContainSubstring(`| ERROR | GET /v1/users/42 cannot load user: timeout | {"request-id":"test-id","route":"/v1/users/{id}"}`))
+	})
+
+	It("should respect the log level", func() {
+		Expect(output).NotTo(ContainSubstring("not logged at level info"))
+	})
+
+	It("should not log without logger", func() {
+		Expect(output).NotTo(ContainSubstring("not logged without logger"))
+	})
+})
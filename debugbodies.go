@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// DefaultRedactedFields lists the JSON fields always redacted by the debug
+// bodies middleware.
+var DefaultRedactedFields = []string{"password", "secret", "token"}
+
+// NewDebugBodiesMiddleware provides a middleware that logs the request and
+// response bodies at debug level, which helps diagnosing integrations. Only
+// the first maxBytes of each body are logged. The values of JSON fields named
+// like one of the given fields or DefaultRedactedFields, ignoring case, are
+// replaced by "***". Only the parts of the request body read by the following
+// middlewares are logged, so the body is still streamed. Register it only for
+// the routes to debug, since buffering bodies is not for free.
+func NewDebugBodiesMiddleware(maxBytes int64, redactFields ...string) Middleware {
+	redact := map[string]bool{}
+	for _, field := range append(append([]string{}, DefaultRedactedFields...), redactFields...) {
+		redact[strings.ToLower(field)] = true
+	}
+
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		reqBody := &bodyCapture{max: maxBytes}
+		if req.Body != nil && req.Body != http.NoBody {
+			req.Body = &captureReader{ReadCloser: req.Body, capture: reqBody}
+		}
+
+		resBody := &bodyCapture{max: maxBytes}
+		orig := ctx.recorder.ResponseWriter
+		ctx.recorder.ResponseWriter = &captureWriter{ResponseWriter: orig, capture: resBody}
+
+		err := ctx.Proceed()
+
+		ctx.recorder.ResponseWriter = orig
+
+		ctx.server.Logger.Debug(ctx.Request, "%s %s request body: %s", req.Method, req.URL, reqBody.format(redact))
+		ctx.server.Logger.Debug(ctx.Request, "%s %s response body: %s", req.Method, req.URL, resBody.format(redact))
+
+		return err
+	}
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// bodyCapture keeps the first max bytes written to it.
+type bodyCapture struct {
+	buf       bytes.Buffer
+	max       int64
+	truncated bool
+}
+
+func (c *bodyCapture) write(p []byte) {
+	remaining := c.max - int64(c.buf.Len())
+	if int64(len(p)) > remaining {
+		if remaining > 0 {
+			c.buf.Write(p[:remaining])
+		}
+		c.truncated = true
+		return
+	}
+
+	c.buf.Write(p)
+}
+
+// format returns the captured body with the given JSON fields redacted.
+func (c *bodyCapture) format(redact map[string]bool) string {
+	body := string(redactJSON(c.buf.Bytes(), redact))
+	if c.truncated {
+		body += "... (truncated)"
+	}
+
+	return body
+}
+
+type captureReader struct {
+	io.ReadCloser
+	capture *bodyCapture
+}
+
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.capture.write(p[:n])
+	return n, err
+}
+
+type captureWriter struct {
+	http.ResponseWriter
+	capture *bodyCapture
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.capture.write(b[:n])
+	return n, err
+}
+
+func (w *captureWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// jsonStringField matches a JSON field with a string value, which might be cut
+// off at the end.
+var jsonStringField = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"(\s*:\s*)"(?:[^"\\]|\\.)*"?`)
+
+// redactJSON replaces the values of the given fields in the given JSON
+// document. Documents that can't be parsed, e.g. because they were truncated,
+// only have string values redacted.
+func redactJSON(body []byte, redact map[string]bool) []byte {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err == nil {
+		if redacted, err := json.Marshal(redactValue(doc, redact)); err == nil {
+			return redacted
+		}
+	}
+
+	return jsonStringField.ReplaceAllFunc(body, func(field []byte) []byte {
+		m := jsonStringField.FindSubmatch(field)
+		if !redact[strings.ToLower(string(m[1]))] {
+			return field
+		}
+
+		return []byte(`"` + string(m[1]) + `"` + string(m[2]) + `"***"`)
+	})
+}
+
+func redactValue(v interface{}, redact map[string]bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, value := range v {
+			if redact[strings.ToLower(k)] {
+				v[k] = "***"
+			} else {
+				v[k] = redactValue(value, redact)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redactValue(value, redact)
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,76 @@
+package server_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("debug bodies middleware", func() {
+	var (
+		ts   *httptest.Server
+		logs chan string
+	)
+
+	BeforeEach(func() {
+		ts = test.NewServer(nil)
+		logs = make(chan string, 1)
+
+		// The logger writes to the os.Stderr it was created with.
+		r, w, err := os.Pipe()
+		Expect(err).To(BeNil())
+		stderr := os.Stderr
+		os.Stderr = w
+		logger := requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test-debug-bodies", Level: "debug"})
+		os.Stderr = stderr
+
+		go func() {
+			var buf bytes.Buffer
+			io.Copy(&buf, r)
+			logs <- buf.String()
+		}()
+
+		srv := srvPkg.NewServer("", "")
+		srv.SetLogger(logger)
+
+		srv.Serve("POST", "/webhook", srvPkg.NewDebugBodiesMiddleware(64, "apiKey"), func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			ioutil.ReadAll(req.Body)
+			return ctx.Response.PlainText(`{"token": "abc", "status": "ok", "padding": "0123456789012345678901234567890123456789"}`, http.StatusOK)
+		})
+
+		ts.Config.Handler = srv.Router
+
+		code, body, _ := test.NewPostRequest(ts.URL+"/webhook", `{"user": {"password": "hunter2", "apikey": 42}, "name": "alice"}`, nil)
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring(`"token": "abc"`))
+
+		ts.Close()
+		w.Close()
+	})
+
+	It("should log the redacted request body", func() {
+		var output string
+		Eventually(logs).Should(Receive(&output))
+
+		Expect(output).To(ContainSubstring(`POST /webhook request body: {"name":"alice","user":{"apikey":"***","password":"***"}}`))
+	})
+
+	It("should log the truncated and redacted response body", func() {
+		var output string
+		Eventually(logs).Should(Receive(&output))
+
+		Expect(output).To(ContainSubstring(`POST /webhook response body: {"token": "***", "status": "ok", "padding": "0123456789012345678... (truncated)`))
+		Expect(output).NotTo(ContainSubstring("abc"))
+	})
+})
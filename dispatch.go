@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Handler returns the http.Handler serving all routes of the server, as used
+// by Listen. Use it to serve the routes using a custom http.Server. Note that
+// the routes are registered to the handler once, when Handler is called for
+// the first time.
+//
+// Unknown paths are answered by the not found handler registered using
+// ServeNotFound, or by the default one. This includes paths outside of the
+// base path set using SetBasePath. Only when registering the routes using
+// RegisterRoutes with a custom http.ServeMux, requests outside of the prefix
+// are answered by that mux instead.
+func (s *Server) Handler() http.Handler {
+	s.handlerOnce.Do(func() {
+		base := "/" + strings.Trim(s.basePath, "/")
+
+		mux := http.NewServeMux()
+		s.RegisterRoutes(mux, base)
+		if base != "/" {
+			mux.Handle("/", http.HandlerFunc(s.serveNotFound))
+		}
+
+		s.handler = s.newDispatcher(mux)
+	})
+
+	return s.handler
+}
+
+// DisableTrace makes the handler returned by Handler respond to all TRACE
+// requests with http.StatusMethodNotAllowed, which protects against
+// cross-site tracing. This is the default.
+func (s *Server) DisableTrace() {
+	s.traceEnabled = false
+}
+
+// EnableTrace makes the handler returned by Handler answer TRACE requests by
+// echoing the received request line and headers, which helps debugging
+// proxies in front of the server. The values of DefaultRedactedHeaders are
+// not echoed. Routes registered for TRACE are never reached either way.
+func (s *Server) EnableTrace() {
+	s.traceEnabled = true
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// newDispatcher wraps the given handler to answer requests that concern the
+// server as a whole, before they are routed.
+func (s *Server) newDispatcher(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if s.serveMaintenance(res, req) {
+			return
+		}
+
+		// Answer the asterisk-form `OPTIONS *` request, which the router cannot
+		// match. Note that http.Server answers it itself, unless its
+		// DisableGeneralOptionsHandler option is set, as Listen does.
+		if req.Method == "OPTIONS" && req.RequestURI == "*" {
+			res.Header().Set("Allow", strings.Join(s.allowedMethods(), ", "))
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if req.Method == "TRACE" {
+			s.serveTrace(res, req)
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+// serveTrace answers the given TRACE request according to EnableTrace and
+// DisableTrace.
+func (s *Server) serveTrace(res http.ResponseWriter, req *http.Request) {
+	if !s.traceEnabled {
+		var allowed []string
+		for _, method := range s.allowedMethods() {
+			if method != "TRACE" {
+				allowed = append(allowed, method)
+			}
+		}
+
+		res.Header().Set("Allow", strings.Join(allowed, ", "))
+		http.Error(res, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	header := req.Header.Clone()
+	for _, name := range DefaultRedactedHeaders {
+		header.Del(name)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s %s\r\n", req.Method, req.RequestURI, req.Proto)
+	fmt.Fprintf(&buf, "Host: %s\r\n", req.Host)
+	header.Write(&buf)
+	buf.WriteString("\r\n")
+
+	res.Header().Set("Content-Type", "message/http")
+	res.WriteHeader(http.StatusOK)
+	res.Write(buf.Bytes())
+}
+
+// allowedMethods returns the sorted methods of all registered routes.
+func (s *Server) allowedMethods() []string {
+	seen := map[string]bool{"OPTIONS": true}
+
+	s.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		methods, err := route.GetMethods()
+		if err != nil {
+			// The route does not restrict methods.
+			return nil
+		}
+
+		for _, method := range methods {
+			seen[method] = true
+		}
+
+		return nil
+	})
+
+	methods := []string{}
+	for method := range seen {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	return methods
+}
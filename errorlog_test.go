@@ -0,0 +1,116 @@
+package server_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+	"github.com/juju/errgo"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("error log format", func() {
+	var (
+		ts   *httptest.Server
+		srv  *srvPkg.Server
+		w    *os.File
+		logs chan string
+	)
+
+	BeforeEach(func() {
+		ts = test.NewServer(nil)
+		logs = make(chan string, 1)
+
+		// The logger writes to the os.Stderr it was created with.
+		var r *os.File
+		var err error
+		r, w, err = os.Pipe()
+		Expect(err).To(BeNil())
+		stderr := os.Stderr
+		os.Stderr = w
+		logger := requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test-error-log", Level: "error"})
+		os.Stderr = stderr
+
+		go func() {
+			var buf bytes.Buffer
+			io.Copy(&buf, r)
+			logs <- buf.String()
+		}()
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(logger)
+
+		srv.Serve("GET", "/fail", srvPkg.NamedMiddleware("failing", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return errgo.Notef(errgo.New("connection refused"), "cannot fetch user")
+		}))
+
+		ts.Config.Handler = srv.Router
+	})
+
+	request := func() string {
+		code, _, _ := test.NewGetRequest(ts.URL + "/fail")
+		Expect(code).To(Equal(http.StatusInternalServerError))
+
+		ts.Close()
+		w.Close()
+
+		var output string
+		Eventually(logs).Should(Receive(&output))
+
+		return output
+	}
+
+	It("should log a panicking error handler with its stack", func() {
+		srv.SetErrorHandler(func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context, err error, code int) {
+			panic("broken error handler")
+		})
+		output := request()
+
+		Expect(output).To(ContainSubstring("GET /fail error handler panicked: broken error handler"))
+		Expect(output).To(ContainSubstring("runtime/debug.Stack"))
+	})
+
+	It("should respond with 500 and log a panicking middleware with its stack", func() {
+		srv.Serve("GET", "/panic", srvPkg.NamedMiddleware("exploding", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			panic("broken middleware")
+		}))
+
+		code, body, _ := test.NewGetRequest(ts.URL + "/panic")
+		Expect(code).To(Equal(http.StatusInternalServerError))
+		Expect(body).To(Equal("Internal Server Error"))
+
+		ts.Close()
+		w.Close()
+
+		var output string
+		Eventually(logs).Should(Receive(&output))
+
+		Expect(output).To(ContainSubstring("GET /panic middleware exploding panicked: broken middleware"))
+		Expect(output).To(ContainSubstring("runtime/debug.Stack"))
+	})
+
+	It("should log the error locations by default", func() {
+		output := request()
+
+		Expect(output).To(ContainSubstring("GET /fail (failing)"))
+		Expect(output).To(ContainSubstring("errorlog_test.go"))
+	})
+
+	It("should log a single line in concise format", func() {
+		srv.SetErrorLogFormat(srvPkg.ErrorLogConcise)
+		output := request()
+
+		Expect(output).To(ContainSubstring("GET /fail (failing) cannot fetch user: connection refused"))
+		Expect(strings.Count(output, "\n")).To(Equal(1))
+		Expect(output).NotTo(ContainSubstring("errorlog_test.go"))
+	})
+})
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/juju/errgo"
+)
+
+var (
+	// InvalidQueryError is the cause of errors returned by Context.ParseQuery
+	// when the query of a request is not properly encoded.
+	InvalidQueryError = errgo.New("invalid query")
+
+	// UnsupportedEncodingError is the cause of errors returned when a content
+	// encoding is not supported by the compression helpers.
+	UnsupportedEncodingError = errgo.New("unsupported encoding")
+
+	// BadGatewayError is the cause of errors returned by the reverse proxy
+	// middleware when the upstream request failed.
+	BadGatewayError = errgo.New("bad gateway")
+
+	// AlreadyStartedError is the cause of errors returned by Listen when the
+	// server was already started.
+	AlreadyStartedError = errgo.New("already started")
+
+	// InvalidParamError is the cause of errors returned by the params
+	// middleware when a route parameter is invalid.
+	InvalidParamError = errgo.New("invalid param")
+
+	// InvalidBodyError is the cause of errors returned by DecodeJSONSchema
+	// when the request body is malformed or violates the schema.
+	InvalidBodyError = errgo.New("invalid body")
+
+	// NotAcceptableError is the cause of errors returned when none of the
+	// media types accepted by the client can be responded.
+	NotAcceptableError = errgo.New("not acceptable")
+
+	// UnsupportedAPIVersionError is the cause of errors returned by the API
+	// version middleware when a request asks for a missing, malformed or
+	// unsupported API version.
+	UnsupportedAPIVersionError = errgo.New("unsupported api version")
+
+	// ResponseNotBufferedError is the cause of errors returned when a
+	// middleware tries to modify a response that is not buffered, see
+	// NewResponseBufferMiddleware.
+	ResponseNotBufferedError = errgo.New("response not buffered")
+)
+
+// IsInvalidQuery returns true if the cause of the given error is
+// InvalidQueryError.
+func IsInvalidQuery(err error) bool {
+	return errgo.Cause(err) == InvalidQueryError
+}
+
+// IsUnsupportedEncoding returns true if the cause of the given error is
+// UnsupportedEncodingError.
+func IsUnsupportedEncoding(err error) bool {
+	return errgo.Cause(err) == UnsupportedEncodingError
+}
+
+// IsBadGateway returns true if the cause of the given error is
+// BadGatewayError.
+func IsBadGateway(err error) bool {
+	return errgo.Cause(err) == BadGatewayError
+}
+
+// IsAlreadyStarted returns true if the cause of the given error is
+// AlreadyStartedError.
+func IsAlreadyStarted(err error) bool {
+	return errgo.Cause(err) == AlreadyStartedError
+}
+
+// IsInvalidParam returns true if the cause of the given error is
+// InvalidParamError.
+func IsInvalidParam(err error) bool {
+	return errgo.Cause(err) == InvalidParamError
+}
+
+// IsInvalidBody returns true if the cause of the given error is
+// InvalidBodyError.
+func IsInvalidBody(err error) bool {
+	return errgo.Cause(err) == InvalidBodyError
+}
+
+// IsNotAcceptable returns true if the cause of the given error is
+// NotAcceptableError.
+func IsNotAcceptable(err error) bool {
+	return errgo.Cause(err) == NotAcceptableError
+}
+
+// IsUnsupportedAPIVersion returns true if the cause of the given error is
+// UnsupportedAPIVersionError.
+func IsUnsupportedAPIVersion(err error) bool {
+	return errgo.Cause(err) == UnsupportedAPIVersionError
+}
+
+// IsResponseNotBuffered returns true if the cause of the given error is
+// ResponseNotBufferedError.
+func IsResponseNotBuffered(err error) bool {
+	return errgo.Cause(err) == ResponseNotBufferedError
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// handledError is returned by Context.Fail to stop the middleware chain
+// without responding with the generic error response.
+var handledError = errgo.New("already handled")
+
+func isHandled(err error) bool {
+	return errgo.Cause(err) == handledError
+}
+
+// deferErrors aggregates the errors of multiple functions registered using
+// Context.DeferErr.
+type deferErrors []error
+
+func (errs deferErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// errorStatusCode returns the status code used to respond with the given
+// error returned by a middleware. Note that a middleware wrapping one of the
+// errors above should use `errgo.Mask(err, errgo.Any)` to preserve its cause.
+func errorStatusCode(err error) int {
+	switch {
+	case IsInvalidQuery(err), IsInvalidParam(err), IsInvalidBody(err), IsUnsupportedAPIVersion(err):
+		return http.StatusBadRequest
+	case IsNotAcceptable(err):
+		return http.StatusNotAcceptable
+	case IsBadGateway(err):
+		return http.StatusBadGateway
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// serverStats holds the runtime metrics of the requests processed by the
+// middleware handlers of a server.
+type serverStats struct {
+	requests expvar.Int
+	errors   expvar.Int
+	inFlight expvar.Int
+
+	// The number of open connections per state, and the last state of every
+	// open connection.
+	connections *expvar.Map
+	connMutex   sync.Mutex
+	connStates  map[net.Conn]http.ConnState
+
+	// The number of errors returned per middleware name.
+	middlewareErrors *expvar.Map
+
+	// The number of requests per route exceeding the target duration of an
+	// SLA monitor middleware.
+	slaBreaches *expvar.Map
+
+	vars *expvar.Map
+}
+
+func newServerStats() *serverStats {
+	stats := &serverStats{
+		connections:      new(expvar.Map).Init(),
+		connStates:       map[net.Conn]http.ConnState{},
+		middlewareErrors: new(expvar.Map).Init(),
+		slaBreaches:      new(expvar.Map).Init(),
+		vars:             new(expvar.Map).Init(),
+	}
+
+	for _, state := range []http.ConnState{http.StateNew, http.StateActive, http.StateIdle} {
+		stats.connections.Add(state.String(), 0)
+	}
+
+	stats.vars.Set("requests", &stats.requests)
+	stats.vars.Set("errors", &stats.errors)
+	stats.vars.Set("in_flight", &stats.inFlight)
+	stats.vars.Set("connections", stats.connections)
+	stats.vars.Set("middleware_errors", stats.middlewareErrors)
+	stats.vars.Set("sla_breaches", stats.slaBreaches)
+
+	return stats
+}
+
+// trackConnState moves the given connection from its last state to the given
+// one. Hijacked and closed connections are forgotten.
+func (s *serverStats) trackConnState(conn net.Conn, state http.ConnState) {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+
+	if last, ok := s.connStates[conn]; ok {
+		s.connections.Add(last.String(), -1)
+	}
+
+	switch state {
+	case http.StateHijacked, http.StateClosed:
+		delete(s.connStates, conn)
+	default:
+		s.connStates[conn] = state
+		s.connections.Add(state.String(), 1)
+	}
+}
+
+// ServeExpvar registers a handler that responds all variables published via
+// the expvar package in JSON format, just like `expvar.Handler()` does. In
+// addition the variable "server" contains the number of total requests,
+// requests a middleware returned an error for, requests currently being
+// processed by the server and open connections per state. Connections are
+// only tracked for servers started using Listen. The variable
+// "middleware_errors" counts the errors per name of the middleware that
+// returned them, see NamedMiddleware, and "sla_breaches" the slow requests
+// per route, see NewSLAMonitorMiddleware.
+// Example: s.ServeExpvar("/debug/vars")
+func (s *Server) ServeExpvar(urlPath string) {
+	handler := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		fmt.Fprintf(res, "{\n")
+		expvar.Do(func(kv expvar.KeyValue) {
+			fmt.Fprintf(res, "%q: %s,\n", kv.Key, kv.Value)
+		})
+		fmt.Fprintf(res, "%q: %s\n", "server", s.stats.vars)
+		fmt.Fprintf(res, "}\n")
+	})
+
+	s.Router.Methods("GET").Path(urlPath).Handler(handler).Name("GET " + urlPath)
+}
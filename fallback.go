@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ServeFallback registers middlewares handling all requests below the given
+// path prefix that do not match any route, e.g. to proxy them to a legacy
+// backend. In contrast to a route registered using a path prefix, a fallback
+// never shadows routes, no matter in which order they are registered. If
+// fallbacks of nested prefixes match, the one of the longest prefix is used.
+// Requests no fallback matches reach the not found handler. Note that
+// requests matching the path but none of the methods of a route are answered
+// as not allowed, not by a fallback.
+// Example: s.ServeFallback("/v1", server.NewReverseProxyMiddleware(legacy, server.ReverseProxyOptions{}))
+func (s *Server) ServeFallback(prefix string, middlewares ...Middleware) {
+	if len(middlewares) == 0 {
+		panic("Missing at least one fallback middleware. Aborting...")
+	}
+	s.checkChainLength("fallback "+prefix, middlewares)
+
+	s.fallbacks = append(s.fallbacks, fallbackRoute{
+		prefix:  strings.TrimSuffix(prefix, "/"),
+		handler: s.NewMiddlewareHandler(middlewares),
+	})
+
+	// Longer prefixes take precedence.
+	sort.SliceStable(s.fallbacks, func(i, j int) bool {
+		return len(s.fallbacks[i].prefix) > len(s.fallbacks[j].prefix)
+	})
+
+	s.setNotFoundHandler(s.notFound)
+}
+
+//------------------------------------------------------------------------------
+// private
+
+type fallbackRoute struct {
+	prefix  string
+	handler http.Handler
+}
+
+func (f fallbackRoute) matches(urlPath string) bool {
+	return f.prefix == "" || urlPath == f.prefix || strings.HasPrefix(urlPath, f.prefix+"/")
+}
+
+// setNotFoundHandler sets the handler for requests not matching any route,
+// behind the fallbacks registered using ServeFallback.
+func (s *Server) setNotFoundHandler(handler http.Handler) {
+	s.notFound = handler
+
+	if len(s.fallbacks) == 0 {
+		s.Router.NotFoundHandler = handler
+		return
+	}
+
+	s.Router.NotFoundHandler = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		for _, fallback := range s.fallbacks {
+			if fallback.matches(req.URL.Path) {
+				fallback.handler.ServeHTTP(res, req)
+				return
+			}
+		}
+
+		s.serveNotFound(res, req)
+	})
+}
+
+// serveMethodNotAllowed answers requests matching the path of a route, but
+// none of its methods. CORS preflight requests for another method of the
+// route run the global middlewares with the metadata of that route, so the
+// CORS middleware can answer them. See NewCORSMiddleware.
+func (s *Server) serveMethodNotAllowed(res http.ResponseWriter, req *http.Request) {
+	if meta, ok := s.preflightMeta(req); ok {
+		handler := s.methodNotAllowed
+		if handler == nil {
+			handler = methodNotAllowed
+		}
+
+		s.newMiddlewareHandler(meta, []Middleware{handler}).ServeHTTP(res, req)
+		return
+	}
+
+	if s.methodNotAllowedHandler != nil {
+		s.methodNotAllowedHandler.ServeHTTP(res, req)
+	} else {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// serveNotFound answers the given request using the not found handler, if
+// any, or the default one.
+func (s *Server) serveNotFound(res http.ResponseWriter, req *http.Request) {
+	if s.notFound != nil {
+		s.notFound.ServeHTTP(res, req)
+	} else {
+		http.NotFound(res, req)
+	}
+}
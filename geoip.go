@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/juju/errgo"
+)
+
+const (
+	GeoCountryKey = "geo-country"
+	GeoASNKey     = "geo-asn"
+)
+
+// GeoInfo describes the origin of a client.
+type GeoInfo struct {
+	// Country is the ISO 3166-1 alpha-2 country code, if known.
+	Country string
+
+	// ASN is the number of the autonomous system the client IP belongs to, if
+	// known.
+	ASN uint32
+}
+
+// GeoDB looks up the origin of IP addresses, e.g. using a MaxMind database.
+type GeoDB interface {
+	// Lookup returns the country and autonomous system of the given IP
+	// address. Unknown values are returned empty.
+	Lookup(ip string) (country string, asn uint32, err error)
+}
+
+// NewGeoIPMiddleware provides a middleware that looks up the origin of
+// `ctx.ClientIP()` in the given database and stores it in `ctx.Geo`. The
+// country and ASN are added to the request context as well, using the keys
+// GeoCountryKey and GeoASNKey, so they are part of the access log. A failing
+// lookup is logged and does not fail the request.
+func NewGeoIPMiddleware(db GeoDB) Middleware {
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		country, asn, err := db.Lookup(ctx.ClientIP())
+		if err != nil {
+			ctx.server.Logger.Error(ctx.Request, "%s %s %#v", req.Method, req.URL, errgo.Mask(err))
+			return ctx.Next()
+		}
+
+		ctx.Geo = GeoInfo{
+			Country: country,
+			ASN:     asn,
+		}
+
+		if country != "" {
+			ctx.Request[GeoCountryKey] = country
+		}
+		if asn != 0 {
+			ctx.Request[GeoASNKey] = asn
+		}
+
+		return ctx.Next()
+	}
+}
@@ -0,0 +1,69 @@
+package server_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type testGeoDB map[string]srvPkg.GeoInfo
+
+func (db testGeoDB) Lookup(ip string) (string, uint32, error) {
+	info, ok := db[ip]
+	if !ok {
+		return "", 0, errors.New("unknown ip")
+	}
+	return info.Country, info.ASN, nil
+}
+
+var _ = Describe("geo IP middleware", func() {
+	var (
+		ts     *httptest.Server
+		reqCtx requestcontext.Ctx
+	)
+
+	setup := func(db testGeoDB) {
+		ts = test.NewServer(nil)
+
+		srv := srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		srv.Serve("GET", "/geo", srvPkg.NewGeoIPMiddleware(db), func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			reqCtx = ctx.Request
+			return ctx.Response.PlainText(fmt.Sprintf("%s %d", ctx.Geo.Country, ctx.Geo.ASN), http.StatusOK)
+		})
+
+		ts.Config.Handler = srv.Router
+	}
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("should store the origin of the client", func() {
+		setup(testGeoDB{"127.0.0.1": {Country: "DE", ASN: 3320}})
+		_, body, _ := test.NewGetRequest(ts.URL + "/geo")
+
+		Expect(body).To(Equal("DE 3320"))
+		Expect(reqCtx).To(HaveKeyWithValue(srvPkg.GeoCountryKey, "DE"))
+		Expect(reqCtx).To(HaveKeyWithValue(srvPkg.GeoASNKey, uint32(3320)))
+	})
+
+	It("should continue if the lookup fails", func() {
+		setup(testGeoDB{})
+		code, body, _ := test.NewGetRequest(ts.URL + "/geo")
+
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal(" 0"))
+		Expect(reqCtx).NotTo(HaveKey(srvPkg.GeoCountryKey))
+	})
+})
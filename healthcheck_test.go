@@ -16,17 +16,15 @@ func TestHealtcheck(t *testing.T) {
 
 var _ = Describe("healtcheck", func() {
 	var (
-		err    error
-		status string
-		hc     srvPkg.Healthchecker
-		info   srvPkg.HealthInfo
+		err  error
+		hc   srvPkg.Healthchecker
+		info srvPkg.HealthInfo
 
 		expectedStatus string
 	)
 
 	BeforeEach(func() {
 		err = nil
-		status = ""
 
 		hc = func() (srvPkg.HealthInfo, error) {
 			return info, nil
@@ -43,6 +41,7 @@ var _ = Describe("healtcheck", func() {
 	AfterEach(func() {
 		info, err = hc.Status()
 
+		Expect(err).To(BeNil())
 		Expect(info.Status).To(Equal(expectedStatus))
 	})
 
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/juju/errgo"
+)
+
+const (
+	DefaultIdempotencyHeader = "Idempotency-Key"
+
+	// IdempotencyReplayedHeader is set on responses replayed by the
+	// idempotency middleware.
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+)
+
+// DefaultIdempotencyMethods lists the methods that are not idempotent by
+// definition.
+var DefaultIdempotencyMethods = []string{"POST", "PATCH"}
+
+// IdempotencyResponse is a response recorded by the idempotency middleware.
+type IdempotencyResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// IdempotencyStore persists the responses recorded by the idempotency
+// middleware. Implementations are responsible for letting keys expire.
+type IdempotencyStore interface {
+	// Get returns the response recorded for the given key, or nil if there is
+	// none.
+	Get(key string) (*IdempotencyResponse, error)
+
+	// Set records the response for the given key.
+	Set(key string, res IdempotencyResponse) error
+}
+
+type IdempotencyOptions struct {
+	// Store persists the responses.
+	Store IdempotencyStore
+
+	// Header is the request header containing the idempotency key. Defaults
+	// to DefaultIdempotencyHeader.
+	Header string
+
+	// Methods lists the request methods the middleware applies to. Defaults
+	// to DefaultIdempotencyMethods.
+	Methods []string
+}
+
+// NewIdempotencyMiddleware provides a middleware that makes retries of
+// requests carrying an idempotency key safe. The response of the first request
+// with a given key is recorded and replayed for all following requests with
+// the same key, method and path, without running the following middlewares
+// again. Responses are not recorded if a middleware returned an error or the
+// status code is http.StatusInternalServerError or above, so the client can
+// retry those. Concurrent requests with the same key wait for each other.
+// Note that this only holds within a single process, even if the store is
+// shared.
+func NewIdempotencyMiddleware(options IdempotencyOptions) Middleware {
+	if options.Header == "" {
+		options.Header = DefaultIdempotencyHeader
+	}
+	if options.Methods == nil {
+		options.Methods = DefaultIdempotencyMethods
+	}
+
+	locks := newKeyLocks()
+
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		key := req.Header.Get(options.Header)
+		if key == "" || !containsString(options.Methods, req.Method) {
+			return ctx.Proceed()
+		}
+		key = req.Method + " " + req.URL.Path + " " + key
+
+		locks.lock(key)
+		defer locks.unlock(key)
+
+		recorded, err := options.Store.Get(key)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if recorded != nil {
+			return replayResponse(ctx.recorder, *recorded)
+		}
+
+		buf, restore := ctx.bufferResponse()
+		err = ctx.Proceed()
+		restore()
+
+		if err == nil && buf.statusCode != 0 && buf.statusCode < http.StatusInternalServerError {
+			if storeErr := storeResponse(options.Store, key, buf); storeErr != nil {
+				ctx.server.Logger.Error(ctx.Request, "%s %s %#v", req.Method, req.URL, errgo.Mask(storeErr))
+			}
+		}
+
+		if flushErr := buf.flushTo(ctx.recorder); flushErr != nil {
+			return errgo.Mask(flushErr)
+		}
+
+		return err
+	}
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore keeping the responses in
+// memory. Recorded responses never expire.
+type MemoryIdempotencyStore struct {
+	mutex     sync.Mutex
+	responses map[string]IdempotencyResponse
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		responses: map[string]IdempotencyResponse{},
+	}
+}
+
+func (m *MemoryIdempotencyStore) Get(key string) (*IdempotencyResponse, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	res, ok := m.responses[key]
+	if !ok {
+		return nil, nil
+	}
+
+	return &res, nil
+}
+
+func (m *MemoryIdempotencyStore) Set(key string, res IdempotencyResponse) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.responses[key] = res
+
+	return nil
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// storeResponse records the given buffered response for the given key.
+func storeResponse(store IdempotencyStore, key string, buf *responseBuffer) error {
+	body, err := buf.bytes()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+
+	recorded := IdempotencyResponse{
+		StatusCode: buf.statusCode,
+		Header:     buf.header.Clone(),
+		Body:       body,
+	}
+
+	return errgo.Mask(store.Set(key, recorded))
+}
+
+// replayResponse writes the given recorded response.
+func replayResponse(w http.ResponseWriter, recorded IdempotencyResponse) error {
+	header := w.Header()
+	for k, v := range recorded.Header {
+		header[k] = append([]string(nil), v...)
+	}
+	header.Set(IdempotencyReplayedHeader, "true")
+
+	w.WriteHeader(recorded.StatusCode)
+	if _, err := w.Write(recorded.Body); err != nil {
+		return errgo.Mask(err)
+	}
+
+	return nil
+}
+
+// keyLocks provides a mutex per key. Mutexes are removed once nobody holds or
+// waits for them anymore.
+type keyLocks struct {
+	mutex sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{
+		locks: map[string]*keyLock{},
+	}
+}
+
+func (k *keyLocks) lock(key string) {
+	k.mutex.Lock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mutex.Unlock()
+
+	l.Lock()
+}
+
+func (k *keyLocks) unlock(key string) {
+	k.mutex.Lock()
+	l := k.locks[key]
+	l.refs--
+	if l.refs == 0 {
+		delete(k.locks, key)
+	}
+	k.mutex.Unlock()
+
+	l.Unlock()
+}
@@ -0,0 +1,112 @@
+package server_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("idempotency middleware", func() {
+	var (
+		ts    *httptest.Server
+		srv   *srvPkg.Server
+		calls int32
+	)
+
+	BeforeEach(func() {
+		ts = test.NewServer(nil)
+		calls = 0
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		idempotency := srvPkg.NewIdempotencyMiddleware(srvPkg.IdempotencyOptions{
+			Store: srvPkg.NewMemoryIdempotencyStore(),
+		})
+		srv.Serve("POST", "/payments", idempotency, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			n := atomic.AddInt32(&calls, 1)
+			time.Sleep(10 * time.Millisecond)
+
+			res.Header().Set("X-Payment", fmt.Sprintf("%d", n))
+			return ctx.Response.PlainText(fmt.Sprintf("payment %d", n), http.StatusCreated)
+		})
+		srv.Serve("POST", "/failing", idempotency, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			atomic.AddInt32(&calls, 1)
+			return errors.New("test error")
+		})
+
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	post := func(path, key string) (*http.Response, string) {
+		header := map[string]string{}
+		if key != "" {
+			header[srvPkg.DefaultIdempotencyHeader] = key
+		}
+		return test.ProcessRequest(test.Post(ts.URL+path, "{}", header))
+	}
+
+	It("should replay the response for a seen key", func() {
+		res1, body1 := post("/payments", "abc")
+		res2, body2 := post("/payments", "abc")
+
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+		Expect(res1.StatusCode).To(Equal(http.StatusCreated))
+		Expect(res2.StatusCode).To(Equal(http.StatusCreated))
+		Expect(body2).To(Equal(body1))
+		Expect(res2.Header.Get("X-Payment")).To(Equal("1"))
+		Expect(res1.Header.Get(srvPkg.IdempotencyReplayedHeader)).To(BeEmpty())
+		Expect(res2.Header.Get(srvPkg.IdempotencyReplayedHeader)).To(Equal("true"))
+	})
+
+	It("should run the chain for new keys and requests without key", func() {
+		post("/payments", "abc")
+		_, body := post("/payments", "def")
+		Expect(body).To(Equal("payment 2"))
+
+		_, body = post("/payments", "")
+		Expect(body).To(Equal("payment 3"))
+	})
+
+	It("should run the chain once for concurrent requests with the same key", func() {
+		var wg sync.WaitGroup
+		bodies := make([]string, 5)
+		for i := range bodies {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, bodies[i] = post("/payments", "abc")
+			}(i)
+		}
+		wg.Wait()
+
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+		for _, body := range bodies {
+			Expect(body).To(Equal("payment 1"))
+		}
+	})
+
+	It("should not record failed requests", func() {
+		res, _ := post("/failing", "abc")
+		Expect(res.StatusCode).To(Equal(http.StatusInternalServerError))
+
+		post("/failing", "abc")
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+	})
+})
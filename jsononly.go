@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errgo"
+)
+
+const jsonContentType = "application/json"
+
+// NewJSONOnlyMiddleware provides a middleware for APIs only responding JSON.
+// Requests whose Accept header does not accept application/json, directly or
+// via "application/*" or "*/*", are rejected with an error with the cause
+// NotAcceptableError, which is responded with http.StatusNotAcceptable.
+// Requests without an Accept header accept anything. If forceContentType is
+// true, the Content-Type of the response is set to application/json before
+// the following middlewares are executed.
+func NewJSONOnlyMiddleware(forceContentType bool) Middleware {
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		if !acceptsMediaType(req.Header.Get("Accept"), jsonContentType) {
+			return errgo.WithCausef(nil, NotAcceptableError, "not acceptable: only %s is supported", jsonContentType)
+		}
+
+		if forceContentType {
+			res.Header().Set("Content-Type", jsonContentType)
+		}
+
+		return ctx.Next()
+	}
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// acceptsMediaType returns true if the given Accept header value accepts the
+// given media type. The quality value of the most specific matching media
+// range decides, so "application/json;q=0, */*" rejects application/json.
+func acceptsMediaType(accept, mediaType string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+
+	typ := strings.SplitN(mediaType, "/", 2)[0]
+
+	specificity := 0
+	q := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+
+		var s int
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case mediaType:
+			s = 3
+		case typ + "/*":
+			s = 2
+		case "*/*":
+			s = 1
+		default:
+			continue
+		}
+		if s < specificity {
+			continue
+		}
+
+		specificity = s
+		q = 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+				q = v
+			}
+		}
+	}
+
+	return q > 0
+}
@@ -0,0 +1,76 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JSON only middleware", func() {
+	var (
+		ts  *httptest.Server
+		srv *srvPkg.Server
+	)
+
+	request := func(path, accept string) (*http.Response, string) {
+		req := test.Get(ts.URL + path)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		return test.ProcessRequest(req)
+	}
+
+	BeforeEach(func() {
+		logger := requestcontext.MustGetLogger(requestcontext.LoggerConfig{
+			Name:  "test",
+			Level: "critical",
+		})
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(logger)
+
+		srv.Serve("GET", "/users", srvPkg.NewJSONOnlyMiddleware(false), func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.Response.Json([]string{"alice"}, http.StatusOK)
+		})
+		srv.Serve("GET", "/raw", srvPkg.NewJSONOnlyMiddleware(true), func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			res.Write([]byte(`["alice"]`))
+			return nil
+		})
+
+		ts = test.NewServer(nil)
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("should accept requests accepting JSON", func() {
+		for _, accept := range []string{"", "application/json", "application/*", "*/*", "text/html, application/json;q=0.5"} {
+			res, body := request("/users", accept)
+			Expect(res.StatusCode).To(Equal(http.StatusOK), accept)
+			Expect(body).To(ContainSubstring("alice"))
+		}
+	})
+
+	It("should reject requests not accepting JSON", func() {
+		for _, accept := range []string{"text/html", "text/*", "application/json;q=0, */*"} {
+			res, body := request("/users", accept)
+			Expect(res.StatusCode).To(Equal(http.StatusNotAcceptable), accept)
+			Expect(body).To(ContainSubstring("only application/json is supported"))
+		}
+	})
+
+	It("should force the content type if requested", func() {
+		res, _ := request("/raw", "application/json")
+		Expect(res.Header.Get("Content-Type")).To(Equal("application/json"))
+	})
+})
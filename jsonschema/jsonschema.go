@@ -0,0 +1,442 @@
+// Package jsonschema implements a server.SchemaValidator supporting a
+// commonly used subset of JSON Schema: type, enum, const, properties,
+// required, additionalProperties, items, minItems, maxItems, minLength,
+// maxLength, pattern, minimum, maximum, exclusiveMinimum and
+// exclusiveMaximum. Annotations like title or description are ignored. Any
+// other keyword, e.g. $ref, allOf or format, makes the schema invalid, so
+// documents are never accepted based on constraints that were not checked.
+//
+//	srv.SetSchemaValidator(jsonschema.Validator{})
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/juju/errgo"
+)
+
+// Validator validates JSON documents against JSON schemas. Schemas are
+// compiled once and kept for the lifetime of the process, so they should not
+// be generated per request.
+type Validator struct{}
+
+// Validate returns the violations of the given schema found in the given
+// document. Each violation is prefixed with the JSON pointer of the offending
+// value. An error is returned if the schema is malformed or uses unsupported
+// keywords.
+func (Validator) Validate(schema, document []byte) ([]string, error) {
+	s, err := compileCached(schema)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, errgo.Notef(err, "invalid document")
+	}
+
+	v := &validation{}
+	v.validate(s, doc, "")
+
+	return v.violations, nil
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// annotations lists the keywords that do not constrain documents.
+var annotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"default":     true,
+	"examples":    true,
+	"readOnly":    true,
+	"writeOnly":   true,
+	"deprecated":  true,
+}
+
+var typeNames = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"object":  true,
+	"array":   true,
+	"string":  true,
+	"number":  true,
+	"integer": true,
+}
+
+// compiled holds the compiled schemas by their source.
+var compiled sync.Map
+
+// schema is a compiled JSON schema. Unset constraints are nil.
+type schema struct {
+	types    []string
+	enum     []interface{}
+	hasConst bool
+	constant interface{}
+
+	properties           map[string]*schema
+	required             []string
+	additionalProperties *schema
+	noAdditional         bool
+
+	items    *schema
+	minItems *float64
+	maxItems *float64
+
+	minLength *float64
+	maxLength *float64
+	pattern   *regexp.Regexp
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+}
+
+func compileCached(source []byte) (*schema, error) {
+	if s, ok := compiled.Load(string(source)); ok {
+		return s.(*schema), nil
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(source, &raw); err != nil {
+		return nil, errgo.Notef(err, "invalid schema")
+	}
+
+	s, err := compile(raw, "")
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+
+	compiled.Store(string(source), s)
+
+	return s, nil
+}
+
+// compile compiles the given raw schema found at the given JSON pointer.
+func compile(raw map[string]interface{}, path string) (*schema, error) {
+	s := &schema{}
+
+	keywords := make([]string, 0, len(raw))
+	for keyword := range raw {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+
+	for _, keyword := range keywords {
+		value := raw[keyword]
+		at := path + "/" + keyword
+
+		var err error
+		switch keyword {
+		case "type":
+			s.types, err = compileTypes(value, at)
+		case "enum":
+			enum, ok := value.([]interface{})
+			if !ok {
+				return nil, invalid(at, "must be an array")
+			}
+			s.enum = enum
+		case "const":
+			s.hasConst = true
+			s.constant = value
+		case "properties":
+			s.properties, err = compileProperties(value, at)
+		case "required":
+			s.required, err = compileStrings(value, at)
+		case "additionalProperties":
+			switch value := value.(type) {
+			case bool:
+				s.noAdditional = !value
+			case map[string]interface{}:
+				s.additionalProperties, err = compile(value, at)
+			default:
+				return nil, invalid(at, "must be a boolean or an object")
+			}
+		case "items":
+			items, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, invalid(at, "must be an object")
+			}
+			s.items, err = compile(items, at)
+		case "minItems":
+			s.minItems, err = compileNumber(value, at)
+		case "maxItems":
+			s.maxItems, err = compileNumber(value, at)
+		case "minLength":
+			s.minLength, err = compileNumber(value, at)
+		case "maxLength":
+			s.maxLength, err = compileNumber(value, at)
+		case "pattern":
+			pattern, ok := value.(string)
+			if !ok {
+				return nil, invalid(at, "must be a string")
+			}
+			if s.pattern, err = regexp.Compile(pattern); err != nil {
+				return nil, errgo.Notef(err, "invalid schema pattern at %s", at)
+			}
+		case "minimum":
+			s.minimum, err = compileNumber(value, at)
+		case "maximum":
+			s.maximum, err = compileNumber(value, at)
+		case "exclusiveMinimum":
+			s.exclusiveMinimum, err = compileNumber(value, at)
+		case "exclusiveMaximum":
+			s.exclusiveMaximum, err = compileNumber(value, at)
+		default:
+			if !annotations[keyword] {
+				return nil, errgo.Newf("invalid schema: unsupported keyword at %s", at)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func compileTypes(value interface{}, path string) ([]string, error) {
+	var types []string
+	if t, ok := value.(string); ok {
+		types = []string{t}
+	} else {
+		var err error
+		if types, err = compileStrings(value, path); err != nil {
+			return nil, invalid(path, "must be a string or an array of strings")
+		}
+	}
+
+	for _, t := range types {
+		if !typeNames[t] {
+			return nil, invalid(path, "unknown type %q", t)
+		}
+	}
+
+	return types, nil
+}
+
+func compileProperties(value interface{}, path string) (map[string]*schema, error) {
+	raw, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, invalid(path, "must be an object")
+	}
+
+	properties := map[string]*schema{}
+	for name, p := range raw {
+		property, ok := p.(map[string]interface{})
+		if !ok {
+			return nil, invalid(path+"/"+name, "must be an object")
+		}
+
+		s, err := compile(property, path+"/"+name)
+		if err != nil {
+			return nil, err
+		}
+		properties[name] = s
+	}
+
+	return properties, nil
+}
+
+func compileStrings(value interface{}, path string) ([]string, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, invalid(path, "must be an array of strings")
+	}
+
+	strs := make([]string, len(list))
+	for i, item := range list {
+		if strs[i], ok = item.(string); !ok {
+			return nil, invalid(path, "must be an array of strings")
+		}
+	}
+
+	return strs, nil
+}
+
+func compileNumber(value interface{}, path string) (*float64, error) {
+	n, ok := value.(float64)
+	if !ok {
+		return nil, invalid(path, "must be a number")
+	}
+
+	return &n, nil
+}
+
+func invalid(path, format string, args ...interface{}) error {
+	return errgo.Newf("invalid schema: %s %s", path, fmt.Sprintf(format, args...))
+}
+
+type validation struct {
+	violations []string
+}
+
+func (v *validation) violate(path, format string, args ...interface{}) {
+	if path == "" {
+		path = "/"
+	}
+
+	v.violations = append(v.violations, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *validation) validate(s *schema, value interface{}, path string) {
+	if s.types != nil && !matchType(s.types, value) {
+		v.violate(path, "must be of type %s", strings.Join(s.types, " or "))
+		return
+	}
+
+	if s.enum != nil {
+		found := false
+		for _, e := range s.enum {
+			if reflect.DeepEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.violate(path, "must be one of %s", marshal(s.enum))
+		}
+	}
+
+	if s.hasConst && !reflect.DeepEqual(s.constant, value) {
+		v.violate(path, "must be %s", marshal(s.constant))
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(s, value, path)
+	case []interface{}:
+		v.validateArray(s, value, path)
+	case string:
+		v.validateString(s, value, path)
+	case float64:
+		v.validateNumber(s, value, path)
+	}
+}
+
+func (v *validation) validateObject(s *schema, value map[string]interface{}, path string) {
+	for _, name := range s.required {
+		if _, ok := value[name]; !ok {
+			v.violate(path+"/"+name, "is required")
+		}
+	}
+
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		switch {
+		case s.properties[name] != nil:
+			v.validate(s.properties[name], value[name], path+"/"+name)
+		case s.noAdditional:
+			v.violate(path+"/"+name, "is not allowed")
+		case s.additionalProperties != nil:
+			v.validate(s.additionalProperties, value[name], path+"/"+name)
+		}
+	}
+}
+
+func (v *validation) validateArray(s *schema, value []interface{}, path string) {
+	n := float64(len(value))
+	if s.minItems != nil && n < *s.minItems {
+		v.violate(path, "must have at least %v items", *s.minItems)
+	}
+	if s.maxItems != nil && n > *s.maxItems {
+		v.violate(path, "must have at most %v items", *s.maxItems)
+	}
+
+	if s.items != nil {
+		for i, item := range value {
+			v.validate(s.items, item, fmt.Sprintf("%s/%d", path, i))
+		}
+	}
+}
+
+func (v *validation) validateString(s *schema, value, path string) {
+	n := float64(utf8.RuneCountInString(value))
+	if s.minLength != nil && n < *s.minLength {
+		v.violate(path, "must be at least %v characters long", *s.minLength)
+	}
+	if s.maxLength != nil && n > *s.maxLength {
+		v.violate(path, "must be at most %v characters long", *s.maxLength)
+	}
+
+	if s.pattern != nil && !s.pattern.MatchString(value) {
+		v.violate(path, "must match %s", s.pattern)
+	}
+}
+
+func (v *validation) validateNumber(s *schema, value float64, path string) {
+	if s.minimum != nil && value < *s.minimum {
+		v.violate(path, "must be at least %v", *s.minimum)
+	}
+	if s.maximum != nil && value > *s.maximum {
+		v.violate(path, "must be at most %v", *s.maximum)
+	}
+	if s.exclusiveMinimum != nil && value <= *s.exclusiveMinimum {
+		v.violate(path, "must be greater than %v", *s.exclusiveMinimum)
+	}
+	if s.exclusiveMaximum != nil && value >= *s.exclusiveMaximum {
+		v.violate(path, "must be less than %v", *s.exclusiveMaximum)
+	}
+}
+
+// matchType returns true if the given value is of one of the given types.
+func matchType(types []string, value interface{}) bool {
+	for _, t := range types {
+		switch t {
+		case "null":
+			if value == nil {
+				return true
+			}
+		case "boolean":
+			if _, ok := value.(bool); ok {
+				return true
+			}
+		case "object":
+			if _, ok := value.(map[string]interface{}); ok {
+				return true
+			}
+		case "array":
+			if _, ok := value.([]interface{}); ok {
+				return true
+			}
+		case "string":
+			if _, ok := value.(string); ok {
+				return true
+			}
+		case "number":
+			if _, ok := value.(float64); ok {
+				return true
+			}
+		case "integer":
+			if f, ok := value.(float64); ok && f == math.Trunc(f) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func marshal(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
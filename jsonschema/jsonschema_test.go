@@ -0,0 +1,104 @@
+package jsonschema_test
+
+import (
+	"testing"
+
+	"github.com/giantswarm/middleware-server/jsonschema"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func TestJSONSchema(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "jsonschema")
+}
+
+var _ = Describe("Validator", func() {
+	validate := func(schema, document string) ([]string, error) {
+		return jsonschema.Validator{}.Validate([]byte(schema), []byte(document))
+	}
+
+	DescribeTable("violations",
+		func(schema, document string, expected []string) {
+			violations, err := validate(schema, document)
+			Expect(err).To(BeNil())
+			if expected == nil {
+				Expect(violations).To(BeEmpty())
+			} else {
+				Expect(violations).To(Equal(expected))
+			}
+		},
+
+		Entry("matching type", `{"type": "string"}`, `"a"`, nil),
+		Entry("wrong type", `{"type": "string"}`, `1`, []string{"/: must be of type string"}),
+		Entry("one of several types", `{"type": ["string", "null"]}`, `null`, nil),
+		Entry("integer", `{"type": "integer"}`, `1.5`, []string{"/: must be of type integer"}),
+		Entry("enum", `{"enum": ["a", "b"]}`, `"c"`, []string{`/: must be one of ["a","b"]`}),
+		Entry("const", `{"const": 1}`, `2`, []string{"/: must be 1"}),
+
+		Entry("required properties",
+			`{"type": "object", "required": ["name"]}`, `{}`,
+			[]string{"/name: is required"}),
+		Entry("nested properties",
+			`{"properties": {"user": {"properties": {"age": {"minimum": 0}}}}}`, `{"user": {"age": -1}}`,
+			[]string{"/user/age: must be at least 0"}),
+		Entry("forbidden additional properties",
+			`{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1, "b": 2}`,
+			[]string{"/b: is not allowed"}),
+		Entry("additional properties schema",
+			`{"additionalProperties": {"type": "string"}}`, `{"a": 1}`,
+			[]string{"/a: must be of type string"}),
+
+		Entry("items",
+			`{"items": {"type": "number"}}`, `[1, "2"]`,
+			[]string{"/1: must be of type number"}),
+		Entry("minItems", `{"minItems": 2}`, `[1]`, []string{"/: must have at least 2 items"}),
+		Entry("maxItems", `{"maxItems": 1}`, `[1, 2]`, []string{"/: must have at most 1 items"}),
+
+		Entry("minLength counts characters", `{"minLength": 2}`, `"é"`, []string{"/: must be at least 2 characters long"}),
+		Entry("maxLength", `{"maxLength": 1}`, `"ab"`, []string{"/: must be at most 1 characters long"}),
+		Entry("pattern", `{"pattern": "^[a-z]+$"}`, `"A"`, []string{"/: must match ^[a-z]+$"}),
+
+		Entry("maximum", `{"maximum": 1}`, `2`, []string{"/: must be at most 1"}),
+		Entry("exclusiveMinimum", `{"exclusiveMinimum": 1}`, `1`, []string{"/: must be greater than 1"}),
+		Entry("exclusiveMaximum", `{"exclusiveMaximum": 1}`, `1`, []string{"/: must be less than 1"}),
+
+		Entry("annotations", `{"title": "User", "description": "A user", "default": {}}`, `{}`, nil),
+	)
+
+	DescribeTable("invalid schemas",
+		func(schema, message string) {
+			_, err := validate(schema, `{}`)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring(message))
+		},
+
+		Entry("$ref", `{"$ref": "#/definitions/user"}`, "unsupported keyword at /$ref"),
+		Entry("allOf", `{"allOf": [{"type": "object"}]}`, "unsupported keyword at /allOf"),
+		Entry("anyOf", `{"anyOf": [{"type": "object"}]}`, "unsupported keyword at /anyOf"),
+		Entry("oneOf", `{"oneOf": [{"type": "object"}]}`, "unsupported keyword at /oneOf"),
+		Entry("not", `{"not": {"type": "string"}}`, "unsupported keyword at /not"),
+		Entry("nested format", `{"properties": {"email": {"format": "email"}}}`, "unsupported keyword at /properties/email/format"),
+		Entry("tuple items", `{"items": [{"type": "string"}]}`, "/items must be an object"),
+		Entry("unknown type", `{"type": "int"}`, `/type unknown type "int"`),
+		Entry("malformed pattern", `{"pattern": "("}`, "invalid schema pattern at /pattern"),
+		Entry("malformed bound", `{"minimum": "1"}`, "/minimum must be a number"),
+		Entry("malformed JSON", `{`, "invalid schema"),
+	)
+
+	It("should reject malformed documents", func() {
+		_, err := validate(`{}`, `{`)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("should validate repeatedly using the compiled schema", func() {
+		schema := `{"pattern": "^a"}`
+		for i := 0; i < 3; i++ {
+			violations, err := validate(schema, `"b"`)
+			Expect(err).To(BeNil())
+			Expect(violations).To(HaveLen(1))
+		}
+	})
+})
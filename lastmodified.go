@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+// NewLastModifiedMiddleware provides a middleware that answers conditional
+// GET and HEAD requests using the modification time returned by the given
+// function. The Last-Modified header is set, and requests whose
+// If-Modified-Since header is not older than the modification time are
+// answered with http.StatusNotModified, without running the following
+// middlewares. Malformed If-Modified-Since headers are ignored, just like the
+// header is when If-None-Match is present. If the function returns the zero
+// time, the request is processed as usual.
+func NewLastModifiedMiddleware(modTime func(req *http.Request) (time.Time, error)) Middleware {
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			return ctx.Next()
+		}
+
+		t, err := modTime(req)
+		if err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+		if t.IsZero() {
+			return ctx.Next()
+		}
+
+		// HTTP dates have a resolution of seconds.
+		t = t.UTC().Truncate(time.Second)
+		res.Header().Set("Last-Modified", t.Format(http.TimeFormat))
+
+		if req.Header.Get("If-None-Match") == "" {
+			if since, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil && !t.After(since) {
+				res.WriteHeader(http.StatusNotModified)
+				return nil
+			}
+		}
+
+		return ctx.Next()
+	}
+}
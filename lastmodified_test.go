@@ -0,0 +1,84 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("last modified middleware", func() {
+	var (
+		ts      *httptest.Server
+		modTime time.Time
+		calls   int
+	)
+
+	BeforeEach(func() {
+		ts = test.NewServer(nil)
+		modTime = time.Date(2020, 5, 1, 12, 0, 0, 500, time.UTC)
+		calls = 0
+
+		srv := srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		lastModified := srvPkg.NewLastModifiedMiddleware(func(req *http.Request) (time.Time, error) {
+			return modTime, nil
+		})
+		srv.Serve("GET", "/report", lastModified, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			calls++
+			return ctx.Response.PlainText("report", http.StatusOK)
+		})
+
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	get := func(header map[string]string) *http.Response {
+		req := test.Get(ts.URL + "/report")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		res, _ := test.ProcessRequest(req)
+		return res
+	}
+
+	It("should set the Last-Modified header", func() {
+		res := get(nil)
+
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(res.Header.Get("Last-Modified")).To(Equal("Fri, 01 May 2020 12:00:00 GMT"))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("should respond 304 if the client's copy is not older", func() {
+		res := get(map[string]string{"If-Modified-Since": "Fri, 01 May 2020 12:00:00 GMT"})
+
+		Expect(res.StatusCode).To(Equal(http.StatusNotModified))
+		Expect(calls).To(Equal(0))
+	})
+
+	It("should respond the resource if the client's copy is older", func() {
+		res := get(map[string]string{"If-Modified-Since": "Fri, 01 May 2020 11:59:59 GMT"})
+
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("should ignore malformed dates", func() {
+		res := get(map[string]string{"If-Modified-Since": "yesterday"})
+
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(calls).To(Equal(1))
+	})
+})
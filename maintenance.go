@@ -0,0 +1,188 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+// SetMaintenanceMode turns the maintenance mode on or off. While it is on, the
+// handler returned by Handler responds to all requests with 503, before they
+// are routed, except for the paths exempted using SetMaintenanceExemptions and
+// the endpoint registered using ServeMaintenance. The response carries the
+// given body, or the status text if it is empty, and a Retry-After header
+// unless retryAfter is zero. The server keeps running, so the mode can be
+// toggled at any time, e.g. for planned maintenance windows.
+// Example: s.SetMaintenanceMode(true, 30*time.Minute, []byte("<h1>Back soon</h1>"))
+func (s *Server) SetMaintenanceMode(on bool, retryAfter time.Duration, body []byte) {
+	s.updateMaintenance(func(state *maintenanceState) {
+		if on {
+			s.Logger.Info(nil, "server enters maintenance mode")
+		} else if state.on {
+			s.Logger.Info(nil, "server leaves maintenance mode")
+		}
+
+		state.on = on
+		state.retryAfter = retryAfter
+		state.body = append([]byte(nil), body...)
+	})
+}
+
+// MaintenanceMode returns true while the maintenance mode is on.
+func (s *Server) MaintenanceMode() bool {
+	return s.maintenanceState().on
+}
+
+// SetMaintenanceExemptions sets the paths of the routes that are still served
+// in maintenance mode, e.g. health checks. Paths are matched exactly and
+// relative to the base path, see SetBasePath. Like the maintenance mode, the
+// exemptions can be changed at any time.
+// Example: s.SetMaintenanceExemptions("/healthcheck", "/ready")
+func (s *Server) SetMaintenanceExemptions(paths ...string) {
+	s.updateMaintenance(func(state *maintenanceState) {
+		state.exemptions = map[string]bool{}
+		for _, path := range paths {
+			state.exemptions[path] = true
+		}
+	})
+}
+
+// ServeMaintenance registers an admin endpoint toggling the maintenance mode
+// at runtime. It is exempted from the maintenance mode itself. A GET request
+// responds "on" or "off". A PUT request turns the maintenance mode on, taking
+// the request body as body of the maintenance response and the query
+// parameter "retry-after" as number of seconds for the Retry-After header. A
+// DELETE request turns it off. The given middlewares are executed in front of
+// the endpoint and should limit access to it, e.g. by authentication.
+// Example: s.ServeMaintenance("/admin/maintenance", adminAuth)
+func (s *Server) ServeMaintenance(urlPath string, middlewares ...Middleware) {
+	s.updateMaintenance(func(state *maintenanceState) {
+		endpoints := map[string]bool{urlPath: true}
+		for path := range state.endpoints {
+			endpoints[path] = true
+		}
+		state.endpoints = endpoints
+	})
+
+	chain := func(handler Middleware) []Middleware {
+		return append(append([]Middleware{}, middlewares...), handler)
+	}
+
+	s.Serve("GET", urlPath, chain(func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		if s.MaintenanceMode() {
+			return ctx.Response.PlainText("on", http.StatusOK)
+		}
+
+		return ctx.Response.PlainText("off", http.StatusOK)
+	})...)
+
+	s.Serve("PUT", urlPath, chain(func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		var retryAfter time.Duration
+		if value := req.URL.Query().Get("retry-after"); value != "" {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				return errgo.WithCausef(nil, InvalidQueryError, "invalid retry-after: %q", value)
+			}
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxMaintenanceBodyBytes+1))
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if len(body) > maxMaintenanceBodyBytes {
+			return errgo.WithCausef(nil, InvalidBodyError, "maintenance body exceeds %d bytes", maxMaintenanceBodyBytes)
+		}
+
+		s.SetMaintenanceMode(true, retryAfter, body)
+		res.WriteHeader(http.StatusNoContent)
+
+		return nil
+	})...)
+
+	s.Serve("DELETE", urlPath, chain(func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		s.SetMaintenanceMode(false, 0, nil)
+		res.WriteHeader(http.StatusNoContent)
+
+		return nil
+	})...)
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// maxMaintenanceBodyBytes is the maximum size of a maintenance body set using
+// the endpoint registered by ServeMaintenance.
+const maxMaintenanceBodyBytes = 1 << 20
+
+// maintenanceState is a snapshot of the maintenance configuration. It is
+// replaced as a whole, so requests can read it without locking.
+type maintenanceState struct {
+	on         bool
+	retryAfter time.Duration
+	body       []byte
+
+	// The paths exempted using SetMaintenanceExemptions and the ones of the
+	// endpoints registered using ServeMaintenance.
+	exemptions map[string]bool
+	endpoints  map[string]bool
+}
+
+func (s *Server) maintenanceState() *maintenanceState {
+	state, _ := s.maintenance.Load().(*maintenanceState)
+	if state == nil {
+		return &maintenanceState{}
+	}
+
+	return state
+}
+
+// updateMaintenance replaces the maintenance state by a copy modified using
+// the given function. The maps of the state must be replaced, not modified.
+func (s *Server) updateMaintenance(update func(state *maintenanceState)) {
+	s.maintenanceMutex.Lock()
+	defer s.maintenanceMutex.Unlock()
+
+	state := *s.maintenanceState()
+	update(&state)
+	s.maintenance.Store(&state)
+}
+
+// serveMaintenance responds to the given request with the maintenance
+// response and returns true, if the maintenance mode is on and the path of the
+// request is not exempted.
+func (s *Server) serveMaintenance(res http.ResponseWriter, req *http.Request) bool {
+	state := s.maintenanceState()
+	if !state.on {
+		return false
+	}
+
+	path := req.URL.Path
+	if base := strings.TrimSuffix("/"+strings.Trim(s.basePath, "/"), "/"); base != "" {
+		path = strings.TrimPrefix(path, base)
+	}
+	if state.exemptions[path] || state.endpoints[path] {
+		return false
+	}
+
+	if state.retryAfter > 0 {
+		seconds := int64((state.retryAfter + time.Second - 1) / time.Second)
+		res.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	}
+
+	if len(state.body) == 0 {
+		http.Error(res, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return true
+	}
+
+	res.Header().Set("Content-Type", http.DetectContentType(state.body))
+	res.WriteHeader(http.StatusServiceUnavailable)
+	res.Write(state.body)
+
+	return true
+}
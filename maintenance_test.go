@@ -0,0 +1,147 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("maintenance mode", func() {
+	var (
+		ts  *httptest.Server
+		srv *srvPkg.Server
+	)
+
+	ok := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+		return ctx.Response.PlainText("ok", http.StatusOK)
+	}
+
+	BeforeEach(func() {
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		srv.Serve("GET", "/v1/hello", ok)
+		srv.Serve("GET", "/healthcheck", ok)
+		srv.SetMaintenanceExemptions("/healthcheck")
+		srv.ServeMaintenance("/admin/maintenance")
+
+		ts = test.NewServer(nil)
+		ts.Config.Handler = srv.Handler()
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("Should serve requests while the maintenance mode is off", func() {
+		code, body, _ := test.NewGetRequest(ts.URL + "/v1/hello")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("ok"))
+		Expect(srv.MaintenanceMode()).To(BeFalse())
+	})
+
+	It("Should respond with the maintenance response", func() {
+		srv.SetMaintenanceMode(true, 90*time.Second, []byte("<html><body>Back soon</body></html>"))
+
+		code, body, res := test.NewGetRequest(ts.URL + "/v1/hello")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(body).To(Equal("<html><body>Back soon</body></html>"))
+		Expect(res.Header.Get("Retry-After")).To(Equal("90"))
+		Expect(res.Header.Get("Content-Type")).To(HavePrefix("text/html"))
+
+		code, _, _ = test.NewGetRequest(ts.URL + "/v1/unknown")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("Should respond with the status text without a body", func() {
+		srv.SetMaintenanceMode(true, 0, nil)
+
+		code, body, res := test.NewGetRequest(ts.URL + "/v1/hello")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(body).To(Equal("Service Unavailable\n"))
+		Expect(res.Header.Get("Retry-After")).To(BeEmpty())
+	})
+
+	It("Should serve exempted paths", func() {
+		srv.SetMaintenanceMode(true, time.Minute, nil)
+
+		code, body, _ := test.NewGetRequest(ts.URL + "/healthcheck")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("ok"))
+	})
+
+	It("Should apply exemptions changed at runtime", func() {
+		srv.SetMaintenanceMode(true, time.Minute, nil)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 10; i++ {
+				srv.SetMaintenanceExemptions("/healthcheck", "/v1/hello")
+			}
+		}()
+		for i := 0; i < 10; i++ {
+			test.NewGetRequest(ts.URL + "/healthcheck")
+		}
+		<-done
+
+		code, _, _ := test.NewGetRequest(ts.URL + "/v1/hello")
+		Expect(code).To(Equal(http.StatusOK))
+
+		srv.SetMaintenanceExemptions()
+		code, _, _ = test.NewGetRequest(ts.URL + "/healthcheck")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+
+		code, _, _ = test.NewGetRequest(ts.URL + "/admin/maintenance")
+		Expect(code).To(Equal(http.StatusOK))
+	})
+
+	It("Should serve requests again once the maintenance mode is off", func() {
+		srv.SetMaintenanceMode(true, time.Minute, nil)
+		srv.SetMaintenanceMode(false, 0, nil)
+
+		code, _, _ := test.NewGetRequest(ts.URL + "/v1/hello")
+		Expect(code).To(Equal(http.StatusOK))
+	})
+
+	It("Should toggle the maintenance mode using the admin endpoint", func() {
+		req, err := http.NewRequest("PUT", ts.URL+"/admin/maintenance?retry-after=60", strings.NewReader("maintenance"))
+		Expect(err).To(BeNil())
+		res, _ := test.ProcessRequest(req)
+		Expect(res.StatusCode).To(Equal(http.StatusNoContent))
+
+		code, body, res := test.NewGetRequest(ts.URL + "/v1/hello")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(body).To(Equal("maintenance"))
+		Expect(res.Header.Get("Retry-After")).To(Equal("60"))
+
+		code, body, _ = test.NewGetRequest(ts.URL + "/admin/maintenance")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("on"))
+
+		req, err = http.NewRequest("DELETE", ts.URL+"/admin/maintenance", nil)
+		Expect(err).To(BeNil())
+		res, _ = test.ProcessRequest(req)
+		Expect(res.StatusCode).To(Equal(http.StatusNoContent))
+
+		code, _, _ = test.NewGetRequest(ts.URL + "/v1/hello")
+		Expect(code).To(Equal(http.StatusOK))
+	})
+
+	It("Should reject an invalid retry-after", func() {
+		req, err := http.NewRequest("PUT", ts.URL+"/admin/maintenance?retry-after=soon", nil)
+		Expect(err).To(BeNil())
+		res, _ := test.ProcessRequest(req)
+		Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+		Expect(srv.MaintenanceMode()).To(BeFalse())
+	})
+})
@@ -3,6 +3,9 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
 
 	"github.com/juju/errgo"
 )
@@ -27,3 +30,62 @@ func NewHealthcheckMiddleware(hc Healthchecker) Middleware {
 		return ctx.Response.Json(hcRes, http.StatusOK)
 	}
 }
+
+// NamedMiddleware attaches a human readable name to the given middleware. The
+// name is used in error logs and by `ctx.ErrorMiddleware()`. Middlewares not
+// wrapped by NamedMiddleware are named after their function, which is not very
+// telling for closures.
+// Example: s.Serve("GET", "/", server.NamedMiddleware("auth", auth), handler)
+func NamedMiddleware(name string, middleware Middleware) Middleware {
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		if ctx.names == nil {
+			ctx.names = map[int]string{}
+		}
+		ctx.names[ctx.running] = name
+
+		return middleware(res, req, ctx)
+	}
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// forMethods wraps the given middleware to only execute it for requests with
+// one of the given methods.
+func forMethods(methods []string, middleware Middleware) Middleware {
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		if !containsString(methods, req.Method) {
+			return ctx.Next()
+		}
+
+		return middleware(res, req, ctx)
+	}
+}
+
+// staticResponse returns a middleware responding the given status code, body
+// and Content-Type.
+func staticResponse(status int, body []byte, contentType string) Middleware {
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		res.Header().Set("Content-Type", contentType)
+		res.WriteHeader(status)
+		_, err := res.Write(body)
+		return errgo.Mask(err)
+	}
+}
+
+// funcName returns the name of the given middleware function without its
+// package path, e.g. "main.middleware.one-fm" or "server.init.func1" for
+// closures.
+func funcName(middleware Middleware) string {
+	f := runtime.FuncForPC(reflect.ValueOf(middleware).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+
+	name := f.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	return name
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errgo"
+)
+
+type ParamType int
+
+const (
+	// ParamString accepts any value. This is the default.
+	ParamString ParamType = iota
+
+	// ParamInt accepts base 10 integers, which are coerced into int64.
+	ParamInt
+
+	// ParamUUID accepts UUIDs in their canonical form.
+	ParamUUID
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// ParamRule describes the values a route parameter may take.
+type ParamRule struct {
+	// Type of the parameter.
+	Type ParamType
+
+	// Pattern the raw value must match, if not nil.
+	Pattern *regexp.Regexp
+
+	// Enum lists the allowed values, if not empty.
+	Enum []string
+
+	// Min and Max limit the value of ParamInt parameters. Each bound is only
+	// checked if HasMin or HasMax respectively is true, so a zero bound can be
+	// expressed as well.
+	Min    int64
+	HasMin bool
+	Max    int64
+	HasMax bool
+}
+
+// NewParamsMiddleware provides a middleware that validates the route
+// parameters in `ctx.MuxVars` against the given rules. If a parameter is
+// missing or violates its rule, an error with the cause InvalidParamError is
+// returned, which responds with http.StatusBadRequest and describes the
+// violation. Otherwise the coerced values are stored in `ctx.Params`, e.g. an
+// int64 for ParamInt parameters.
+// Example: s.Serve("GET", "/users/{id}", server.NewParamsMiddleware(map[string]server.ParamRule{"id": {Type: server.ParamInt, Min: 1, HasMin: true}}), handler)
+func NewParamsMiddleware(rules map[string]ParamRule) Middleware {
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		params := map[string]interface{}{}
+
+		for name, rule := range rules {
+			raw, ok := ctx.MuxVars[name]
+			if !ok {
+				return errgo.WithCausef(nil, InvalidParamError, "invalid param '%s': missing", name)
+			}
+
+			value, reason := rule.check(raw)
+			if reason != "" {
+				return errgo.WithCausef(nil, InvalidParamError, "invalid param '%s': %s", name, reason)
+			}
+
+			params[name] = value
+		}
+
+		ctx.Params = params
+
+		return ctx.Next()
+	}
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// check validates the given raw value and returns its coerced value. If the
+// value is invalid, the reason is returned.
+func (r ParamRule) check(raw string) (interface{}, string) {
+	if r.Pattern != nil && !r.Pattern.MatchString(raw) {
+		return nil, "must match " + r.Pattern.String()
+	}
+
+	if len(r.Enum) > 0 && !containsString(r.Enum, raw) {
+		return nil, "must be one of " + strings.Join(r.Enum, ", ")
+	}
+
+	switch r.Type {
+	case ParamInt:
+		i, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, "must be an integer"
+		}
+
+		if r.HasMin && r.HasMax && (i < r.Min || i > r.Max) {
+			return nil, "must be between " + strconv.FormatInt(r.Min, 10) + " and " + strconv.FormatInt(r.Max, 10)
+		}
+		if r.HasMin && i < r.Min {
+			return nil, "must be at least " + strconv.FormatInt(r.Min, 10)
+		}
+		if r.HasMax && i > r.Max {
+			return nil, "must be at most " + strconv.FormatInt(r.Max, 10)
+		}
+
+		return i, ""
+	case ParamUUID:
+		if !uuidPattern.MatchString(raw) {
+			return nil, "must be a UUID"
+		}
+	}
+
+	return raw, ""
+}
@@ -0,0 +1,106 @@
+package server_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("params middleware", func() {
+	var (
+		ts  *httptest.Server
+		srv *srvPkg.Server
+	)
+
+	BeforeEach(func() {
+		ts = test.NewServer(nil)
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		params := srvPkg.NewParamsMiddleware(map[string]srvPkg.ParamRule{
+			"org":  {Pattern: regexp.MustCompile(`^[a-z]+$`)},
+			"id":   {Type: srvPkg.ParamInt, Min: 1, HasMin: true, Max: 100, HasMax: true},
+			"kind": {Enum: []string{"user", "team"}},
+			"uuid": {Type: srvPkg.ParamUUID},
+		})
+		srv.Serve("GET", "/min/{id}", srvPkg.NewParamsMiddleware(map[string]srvPkg.ParamRule{
+			"id": {Type: srvPkg.ParamInt, Min: 1, HasMin: true},
+		}), func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.Response.PlainText(fmt.Sprint(ctx.Params["id"]), http.StatusOK)
+		})
+		srv.Serve("GET", "/max/{id}", srvPkg.NewParamsMiddleware(map[string]srvPkg.ParamRule{
+			"id": {Type: srvPkg.ParamInt, Max: 0, HasMax: true},
+		}), func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.Response.PlainText(fmt.Sprint(ctx.Params["id"]), http.StatusOK)
+		})
+		srv.Serve("GET", "/{org}/{kind}/{id}/{uuid}", params, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.Response.PlainText(fmt.Sprintf("%T %v", ctx.Params["id"], ctx.Params["id"]), http.StatusOK)
+		})
+
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("should coerce valid params", func() {
+		code, body, _ := test.NewGetRequest(ts.URL + "/acme/user/42/123e4567-e89b-12d3-a456-426614174000")
+
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("int64 42"))
+	})
+
+	expectBadRequest := func(path, message string) {
+		code, body, _ := test.NewGetRequest(ts.URL + path)
+
+		Expect(code).To(Equal(http.StatusBadRequest))
+		Expect(body).To(Equal(message))
+	}
+
+	It("should reject params not matching the pattern", func() {
+		expectBadRequest("/ACME/user/42/123e4567-e89b-12d3-a456-426614174000", "invalid param 'org': must match ^[a-z]+$")
+	})
+
+	It("should reject params not part of the enum", func() {
+		expectBadRequest("/acme/robot/42/123e4567-e89b-12d3-a456-426614174000", "invalid param 'kind': must be one of user, team")
+	})
+
+	It("should reject integers out of range", func() {
+		expectBadRequest("/acme/user/foo/123e4567-e89b-12d3-a456-426614174000", "invalid param 'id': must be an integer")
+		expectBadRequest("/acme/user/0/123e4567-e89b-12d3-a456-426614174000", "invalid param 'id': must be between 1 and 100")
+	})
+
+	It("should check a lower bound only", func() {
+		code, body, _ := test.NewGetRequest(ts.URL + "/min/5")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("5"))
+
+		code, _, _ = test.NewGetRequest(ts.URL + "/min/1000000")
+		Expect(code).To(Equal(http.StatusOK))
+
+		expectBadRequest("/min/0", "invalid param 'id': must be at least 1")
+	})
+
+	It("should check an upper bound only", func() {
+		code, body, _ := test.NewGetRequest(ts.URL + "/max/-5")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("-5"))
+
+		expectBadRequest("/max/1", "invalid param 'id': must be at most 0")
+	})
+
+	It("should reject malformed UUIDs", func() {
+		expectBadRequest("/acme/user/42/123", "invalid param 'uuid': must be a UUID")
+	})
+})
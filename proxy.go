@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+type ReverseProxyOptions struct {
+	// Transport used to perform the upstream requests. Defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// FlushInterval specifies the interval to flush the response to the client
+	// while copying the upstream response body. Zero disables periodic flushes.
+	FlushInterval time.Duration
+}
+
+// NewReverseProxyMiddleware provides a middleware that forwards the request to
+// the given target and copies the upstream response to the client. The path
+// of the request is appended to the path of the target. Register it as the
+// last middleware of a route, so authentication or rate limiting middlewares
+// run before. The X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto
+// headers are set for the upstream request. The upstream request is canceled
+// when the client goes away. If the upstream request fails, an error with the
+// cause BadGatewayError is returned, which responds with
+// http.StatusBadGateway.
+func NewReverseProxyMiddleware(target *url.URL, options ReverseProxyOptions) Middleware {
+	director := httputil.NewSingleHostReverseProxy(target).Director
+
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		var proxyErr error
+
+		proxy := &httputil.ReverseProxy{
+			Director: func(outReq *http.Request) {
+				director(outReq)
+
+				outReq.Header.Set("X-Forwarded-Host", req.Host)
+				if req.TLS != nil {
+					outReq.Header.Set("X-Forwarded-Proto", "https")
+				} else {
+					outReq.Header.Set("X-Forwarded-Proto", "http")
+				}
+			},
+			Transport:     options.Transport,
+			FlushInterval: options.FlushInterval,
+			ErrorHandler: func(res http.ResponseWriter, outReq *http.Request, err error) {
+				proxyErr = err
+			},
+		}
+
+		proxy.ServeHTTP(res, req)
+
+		if proxyErr != nil {
+			if req.Context().Err() != nil {
+				// The client went away. There is nobody left to respond to.
+				return nil
+			}
+
+			return errgo.WithCausef(proxyErr, BadGatewayError, "upstream request to '%s' failed", target.Host)
+		}
+
+		return nil
+	}
+}
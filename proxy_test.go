@@ -0,0 +1,115 @@
+package server_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("reverse proxy middleware", func() {
+	var (
+		ts       *httptest.Server
+		upstream *httptest.Server
+		srv      *srvPkg.Server
+		code     int
+		body     string
+		res      *http.Response
+	)
+
+	BeforeEach(func() {
+		upstream = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.Header().Set("X-Upstream-Host", req.Header.Get("X-Forwarded-Host"))
+			res.WriteHeader(http.StatusCreated)
+			res.Write([]byte("upstream " + req.URL.Path))
+		}))
+
+		ts = test.NewServer(nil)
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+		upstream.Close()
+	})
+
+	Context("reachable upstream", func() {
+		BeforeEach(func() {
+			target, err := url.Parse(upstream.URL + "/base")
+			Expect(err).To(BeNil())
+
+			srv.Serve("GET", "/proxy", srvPkg.NewReverseProxyMiddleware(target, srvPkg.ReverseProxyOptions{}))
+
+			code, body, res = test.NewGetRequest(ts.URL + "/proxy")
+		})
+
+		It("should copy the upstream response", func() {
+			Expect(code).To(Equal(http.StatusCreated))
+			Expect(body).To(Equal("upstream /base/proxy"))
+		})
+
+		It("should set the X-Forwarded-Host header", func() {
+			Expect(res.Header.Get("X-Upstream-Host")).To(Equal(ts.Listener.Addr().String()))
+		})
+	})
+
+	Context("upstream closing the connection mid-body", func() {
+		BeforeEach(func() {
+			upstream.Config.Handler = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				// The chunked response could be ended cleanly by the proxy.
+				res.WriteHeader(http.StatusOK)
+				res.Write([]byte("partial"))
+				res.(http.Flusher).Flush()
+
+				conn, _, err := res.(http.Hijacker).Hijack()
+				Expect(err).To(BeNil())
+				conn.Close()
+			})
+
+			target, err := url.Parse(upstream.URL)
+			Expect(err).To(BeNil())
+
+			srv.Serve("GET", "/proxy", srvPkg.NewReverseProxyMiddleware(target, srvPkg.ReverseProxyOptions{}))
+		})
+
+		It("should abort the response", func() {
+			// Depending on what was sent already, the client fails reading the
+			// headers or the body, but never receives a complete response.
+			res, err := http.Get(ts.URL + "/proxy")
+			if err == nil {
+				defer res.Body.Close()
+				_, err = ioutil.ReadAll(res.Body)
+			}
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("unreachable upstream", func() {
+		BeforeEach(func() {
+			target, err := url.Parse(upstream.URL)
+			Expect(err).To(BeNil())
+			upstream.Close()
+
+			srv.Serve("GET", "/proxy", srvPkg.NewReverseProxyMiddleware(target, srvPkg.ReverseProxyOptions{}))
+
+			code, body, _ = test.NewGetRequest(ts.URL + "/proxy")
+		})
+
+		It("should respond with status code 502", func() {
+			Expect(code).To(Equal(http.StatusBadGateway))
+			Expect(body).To(ContainSubstring("upstream request"))
+		})
+	})
+})
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/juju/errgo"
+)
+
+// QuotaUsage describes the resources consumed by a principal.
+type QuotaUsage struct {
+	// Requests is the number of requests processed.
+	Requests int64
+
+	// Bytes is the number of request and response body bytes transferred.
+	Bytes int64
+}
+
+// QuotaStore persists the usage of principals. Implementations are
+// responsible for resetting the usage per billing period, e.g. by letting keys
+// expire.
+type QuotaStore interface {
+	// Usage returns the current usage of the given principal.
+	Usage(key string) (QuotaUsage, error)
+
+	// Add adds the given usage to the current usage of the given principal.
+	Add(key string, usage QuotaUsage) error
+}
+
+type QuotaOptions struct {
+	// Store persists the usage.
+	Store QuotaStore
+
+	// Key returns the principal the request is accounted to. Requests for
+	// which an empty key is returned are not accounted. Defaults to the client
+	// IP.
+	Key func(req *http.Request, ctx *Context) string
+
+	// MaxRequests is the number of requests a principal may send. Zero means
+	// unlimited.
+	MaxRequests int64
+
+	// MaxBytes is the number of body bytes a principal may transfer. Zero
+	// means unlimited.
+	MaxBytes int64
+}
+
+// NewQuotaMiddleware provides a middleware that enforces a usage budget per
+// principal. Requests of principals that exhausted their budget are answered
+// with http.StatusTooManyRequests. Otherwise the request and the number of
+// bytes read and written are added to the usage, once the response was
+// written.
+func NewQuotaMiddleware(options QuotaOptions) Middleware {
+	if options.Key == nil {
+		options.Key = func(req *http.Request, ctx *Context) string {
+			return ctx.ClientIP()
+		}
+	}
+
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		key := options.Key(req, ctx)
+		if key == "" {
+			return ctx.Next()
+		}
+
+		usage, err := options.Store.Usage(key)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+
+		if (options.MaxRequests > 0 && usage.Requests >= options.MaxRequests) || (options.MaxBytes > 0 && usage.Bytes >= options.MaxBytes) {
+			return ctx.Fail(http.StatusTooManyRequests, "quota exceeded")
+		}
+
+		ctx.Defer(func() {
+			usage := QuotaUsage{
+				Requests: 1,
+				Bytes:    ctx.BytesIn() + ctx.BytesOut(),
+			}
+
+			if err := options.Store.Add(key, usage); err != nil {
+				ctx.server.Logger.Error(ctx.Request, "%s %s %#v", req.Method, req.URL, errgo.Mask(err))
+			}
+		})
+
+		return ctx.Next()
+	}
+}
+
+// MemoryQuotaStore is a QuotaStore keeping the usage in memory. Call Reset to
+// start a new billing period.
+type MemoryQuotaStore struct {
+	mutex sync.Mutex
+	usage map[string]QuotaUsage
+}
+
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{
+		usage: map[string]QuotaUsage{},
+	}
+}
+
+func (m *MemoryQuotaStore) Usage(key string) (QuotaUsage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.usage[key], nil
+}
+
+func (m *MemoryQuotaStore) Add(key string, usage QuotaUsage) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	current := m.usage[key]
+	current.Requests += usage.Requests
+	current.Bytes += usage.Bytes
+	m.usage[key] = current
+
+	return nil
+}
+
+// Reset clears the usage of all principals.
+func (m *MemoryQuotaStore) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.usage = map[string]QuotaUsage{}
+}
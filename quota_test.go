@@ -0,0 +1,79 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("quota middleware", func() {
+	var (
+		ts    *httptest.Server
+		srv   *srvPkg.Server
+		store *srvPkg.MemoryQuotaStore
+	)
+
+	request := func(user string) int {
+		req := test.Get(ts.URL + "/report")
+		req.Header.Set("X-User", user)
+
+		res, _ := test.ProcessRequest(req)
+		return res.StatusCode
+	}
+
+	BeforeEach(func() {
+		ts = test.NewServer(nil)
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		store = srvPkg.NewMemoryQuotaStore()
+		quota := srvPkg.NewQuotaMiddleware(srvPkg.QuotaOptions{
+			Store: store,
+			Key: func(req *http.Request, ctx *srvPkg.Context) string {
+				return req.Header.Get("X-User")
+			},
+			MaxRequests: 2,
+		})
+		srv.Serve("GET", "/report", quota, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.Response.PlainText("report", http.StatusOK)
+		})
+
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("should reject requests once the budget is exhausted", func() {
+		Expect(request("alice")).To(Equal(http.StatusOK))
+		Expect(request("alice")).To(Equal(http.StatusOK))
+		Expect(request("alice")).To(Equal(http.StatusTooManyRequests))
+
+		Expect(request("bob")).To(Equal(http.StatusOK))
+	})
+
+	It("should account the bytes written", func() {
+		request("alice")
+
+		usage, err := store.Usage("alice")
+		Expect(err).To(BeNil())
+		Expect(usage).To(Equal(srvPkg.QuotaUsage{Requests: 1, Bytes: int64(len("report"))}))
+	})
+
+	It("should start over after a reset", func() {
+		request("alice")
+		request("alice")
+		store.Reset()
+
+		Expect(request("alice")).To(Equal(http.StatusOK))
+	})
+})
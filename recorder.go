@@ -0,0 +1,268 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/juju/errgo"
+)
+
+// responseRecorder wraps the http.ResponseWriter passed to the middlewares to
+// keep track of the response written by them.
+type responseRecorder struct {
+	http.ResponseWriter
+
+	statusCode int
+	written    bool
+	size       int64
+
+	// Content-Type set for responses with a body that do not set one.
+	defaultContentType string
+}
+
+// Flush proxies http.Flusher's functionality if it is available on ResponseWriter
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify proxies http.CloseNotifier functionality
+func (r *responseRecorder) CloseNotify() <-chan bool {
+	cn := r.ResponseWriter.(http.CloseNotifier)
+	return cn.CloseNotify()
+}
+
+// Write marks the response as written with http.StatusOK, if no status code
+// was written before, and sums the number of bytes written.
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if !r.written {
+		r.applyDefaultContentType(http.StatusOK)
+		r.statusCode = http.StatusOK
+		r.written = true
+	}
+
+	n, err := r.ResponseWriter.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+// WriteHeader captures the status code and writes through to the wrapped ResponseWriter.
+func (r *responseRecorder) WriteHeader(code int) {
+	if !r.written {
+		r.applyDefaultContentType(code)
+		r.statusCode = code
+		r.written = true
+	}
+
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// applyDefaultContentType sets the default content type, if any, unless the
+// response already has one or has no body due to the given status code.
+func (r *responseRecorder) applyDefaultContentType(code int) {
+	if r.defaultContentType == "" || code == http.StatusNoContent || code == http.StatusNotModified {
+		return
+	}
+
+	header := r.Header()
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", r.defaultContentType)
+	}
+}
+
+// Hijack lets the caller take over the connection.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errgo.New("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// reset discards the state recorded so far.
+func (r *responseRecorder) reset() {
+	r.statusCode = 0
+	r.written = false
+	r.size = 0
+}
+
+// bodyCounter wraps a request body to sum the number of bytes read from it.
+type bodyCounter struct {
+	io.ReadCloser
+	size int64
+}
+
+func (b *bodyCounter) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+	return n, err
+}
+
+// responseBuffer is a http.ResponseWriter keeping the response until it is
+// flushed to another http.ResponseWriter. Bodies exceeding the limit are
+// spooled to a temporary file, which is removed by close.
+type responseBuffer struct {
+	header     http.Header
+	statusCode int
+
+	body  bytes.Buffer
+	file  *os.File
+	limit int64
+}
+
+// newResponseBuffer creates a responseBuffer starting with a copy of the
+// current response headers. It is closed once the middleware chain finished.
+func (c *Context) newResponseBuffer() *responseBuffer {
+	buf := &responseBuffer{
+		header: http.Header{},
+		limit:  c.server.responseBufferLimit,
+	}
+
+	for k, v := range c.recorder.ResponseWriter.Header() {
+		buf.header[k] = append([]string(nil), v...)
+	}
+
+	c.Defer(buf.close)
+
+	return buf
+}
+
+// bufferResponse makes the following middlewares write the response to a new
+// responseBuffer, also through writers wrapping it, until the returned
+// function restores the previous response writer.
+func (c *Context) bufferResponse() (*responseBuffer, func()) {
+	orig := c.recorder.ResponseWriter
+	buf := c.newResponseBuffer()
+	c.recorder.ResponseWriter = buf
+	c.buffers = append(c.buffers, buf)
+
+	return buf, func() {
+		c.recorder.ResponseWriter = orig
+		c.recorder.reset()
+		c.buffers = c.buffers[:len(c.buffers)-1]
+	}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if b.statusCode == 0 {
+		b.statusCode = http.StatusOK
+	}
+
+	if b.file == nil && b.limit > 0 && int64(b.body.Len()+len(p)) > b.limit {
+		if err := b.spool(); err != nil {
+			return 0, errgo.Mask(err)
+		}
+	}
+
+	if b.file != nil {
+		return b.file.Write(p)
+	}
+
+	return b.body.Write(p)
+}
+
+func (b *responseBuffer) WriteHeader(code int) {
+	if b.statusCode == 0 {
+		b.statusCode = code
+	}
+}
+
+// spool moves the body buffered in memory to a temporary file.
+func (b *responseBuffer) spool() error {
+	file, err := ioutil.TempFile("", "middleware-server-buffer-")
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	b.file = file
+
+	if _, err := b.body.WriteTo(file); err != nil {
+		return errgo.Mask(err)
+	}
+
+	return nil
+}
+
+// bytes returns the buffered body.
+func (b *responseBuffer) bytes() ([]byte, error) {
+	if b.file == nil {
+		return b.body.Bytes(), nil
+	}
+
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, errgo.Mask(err)
+	}
+
+	content, err := ioutil.ReadAll(b.file)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+
+	return content, nil
+}
+
+// replace discards the buffered body and buffers the given one instead.
+func (b *responseBuffer) replace(body []byte) error {
+	b.close()
+	b.body.Reset()
+
+	if _, err := b.Write(body); err != nil {
+		return errgo.Mask(err)
+	}
+
+	return nil
+}
+
+// flushTo replaces the headers of the given http.ResponseWriter with the
+// buffered ones and writes the buffered response, if any.
+func (b *responseBuffer) flushTo(w http.ResponseWriter) error {
+	header := w.Header()
+	for k := range header {
+		delete(header, k)
+	}
+	for k, v := range b.header {
+		header[k] = v
+	}
+
+	if b.statusCode == 0 {
+		return nil
+	}
+
+	w.WriteHeader(b.statusCode)
+
+	if b.file == nil {
+		if _, err := w.Write(b.body.Bytes()); err != nil {
+			return errgo.Mask(err)
+		}
+		return nil
+	}
+
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return errgo.Mask(err)
+	}
+	if _, err := io.Copy(w, b.file); err != nil {
+		return errgo.Mask(err)
+	}
+
+	return nil
+}
+
+// close removes the temporary file, if any.
+func (b *responseBuffer) close() {
+	if b.file == nil {
+		return
+	}
+
+	b.file.Close()
+	os.Remove(b.file.Name())
+	b.file = nil
+}
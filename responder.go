@@ -3,6 +3,9 @@ package server
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+
+	"github.com/juju/errgo"
 )
 
 type Response struct {
@@ -10,7 +13,7 @@ type Response struct {
 }
 
 func (response *Response) Json(result interface{}, code int) error {
-	response.w.Header().Add("Content-Type", "application/json")
+	response.w.Header().Set("Content-Type", "application/json")
 	response.w.WriteHeader(code)
 	return json.NewEncoder(response.w).Encode(result)
 }
@@ -50,3 +53,18 @@ func (response *Response) Redirect(location string, code int) error {
 	response.w.WriteHeader(code)
 	return nil
 }
+
+// Precompressed sends a body that is already compressed using the given
+// content encoding, e.g. gzip compressed content from a cache. The compress
+// middleware does not compress it again. The caller has to make sure the
+// client accepts the encoding, see NegotiateEncoding.
+func (response *Response) Precompressed(encoding string, code int, body []byte) error {
+	header := response.w.Header()
+	header.Set("Content-Encoding", encoding)
+	header.Add("Vary", "Accept-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	response.w.WriteHeader(code)
+	_, err := response.w.Write(body)
+	return errgo.Mask(err)
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+const (
+	DefaultRetryAttempts     = 3
+	DefaultRetryBackoff      = 100 * time.Millisecond
+	DefaultRetryMaxBodyBytes = 1 << 20
+)
+
+var (
+	DefaultRetryMethods     = []string{"GET", "HEAD", "PUT", "DELETE"}
+	DefaultRetryStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+)
+
+type RetryOptions struct {
+	// Attempts is the maximum number of times the downstream chain is executed.
+	Attempts int
+
+	// Backoff is the time to wait before the first retry. It doubles with
+	// every further retry.
+	Backoff time.Duration
+
+	// Methods lists the request methods that are retried. Only idempotent
+	// methods should be listed here.
+	Methods []string
+
+	// StatusCodes lists the response status codes considered transient.
+	StatusCodes []int
+
+	// RetryError decides whether an error returned by the downstream chain is
+	// transient. If nil, all errors are retried.
+	RetryError func(err error) bool
+
+	// MaxBodyBytes is the maximum size of a request body that is recorded to
+	// be replayed. Requests with larger bodies are not retried.
+	MaxBodyBytes int64
+}
+
+// NewRetryMiddleware provides a middleware that executes the downstream chain
+// again when it failed transiently, i.e. returned an error or responded with
+// one of the configured status codes. The request body is recorded while it
+// is read, to be replayed for every attempt. The response of each attempt is
+// buffered as well, so only the response of the last attempt reaches the
+// client. Because of that, downstream middlewares can neither stream
+// responses nor hijack the connection. If the response was already written
+// when this middleware is executed, the downstream chain is not retried. Zero
+// options fall back to the defaults above.
+func NewRetryMiddleware(options RetryOptions) Middleware {
+	if options.Attempts <= 0 {
+		options.Attempts = DefaultRetryAttempts
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = DefaultRetryBackoff
+	}
+	if options.Methods == nil {
+		options.Methods = DefaultRetryMethods
+	}
+	if options.StatusCodes == nil {
+		options.StatusCodes = DefaultRetryStatusCodes
+	}
+	if options.MaxBodyBytes <= 0 {
+		options.MaxBodyBytes = DefaultRetryMaxBodyBytes
+	}
+
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		if !containsString(options.Methods, req.Method) || ctx.ResponseWritten() {
+			return ctx.Proceed()
+		}
+
+		var body *replayBody
+		if req.Body != nil && req.Body != http.NoBody {
+			body = &replayBody{body: req.Body, max: options.MaxBodyBytes}
+			req.Body = body
+			defer body.close()
+		}
+
+		next := ctx.next
+		backoff := options.Backoff
+
+		for attempt := 1; ; attempt++ {
+			buf, restore := ctx.bufferResponse()
+			ctx.recorder.reset()
+			ctx.next = next
+
+			err := ctx.Proceed()
+			restore()
+
+			transient := containsInt(options.StatusCodes, buf.statusCode)
+			if err != nil && !isHandled(err) {
+				transient = options.RetryError == nil || options.RetryError(err)
+			}
+
+			if !transient || attempt >= options.Attempts || !body.rewind() || !sleepContext(req, backoff) {
+				if flushErr := buf.flushTo(ctx.recorder); flushErr != nil {
+					return errgo.Mask(flushErr)
+				}
+
+				return err
+			}
+
+			backoff *= 2
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// replayBody records a request body while it is read, so it can be replayed
+// for another attempt. The body is only read on demand. That way a request
+// sent with "Expect: 100-continue" can still be rejected by a downstream
+// middleware, before the client sends the body.
+type replayBody struct {
+	body     io.ReadCloser
+	max      int64
+	recorded []byte
+	overflow bool
+	eof      bool
+	replay   *bytes.Reader
+}
+
+func (b *replayBody) Read(p []byte) (int, error) {
+	if b.replay != nil && b.replay.Len() > 0 {
+		return b.replay.Read(p)
+	}
+	if b.eof {
+		return 0, io.EOF
+	}
+
+	n, err := b.body.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	if !b.overflow {
+		if int64(len(b.recorded)+n) > b.max {
+			b.overflow = true
+			b.recorded = nil
+		} else {
+			b.recorded = append(b.recorded, p[:n]...)
+		}
+	}
+
+	return n, err
+}
+
+// Close does nothing, since the body is read again by the next attempt.
+// Downstream middlewares like the reverse proxy close the body after every
+// attempt. The original body is closed by close once all attempts finished.
+func (b *replayBody) Close() error {
+	return nil
+}
+
+// close closes the original body. A nil body is ignored.
+func (b *replayBody) close() error {
+	if b == nil {
+		return nil
+	}
+
+	return b.body.Close()
+}
+
+// rewind makes the body readable from the start again. False is returned if
+// the body exceeded the maximum size to be recorded. A nil body can always be
+// rewound.
+func (b *replayBody) rewind() bool {
+	if b == nil {
+		return true
+	}
+	if b.overflow {
+		return false
+	}
+
+	b.replay = bytes.NewReader(b.recorded)
+
+	return true
+}
+
+// sleepContext waits for the given duration. False is returned if the request
+// is canceled in the meantime.
+func sleepContext(req *http.Request, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-req.Context().Done():
+		return false
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsInt(list []int, i int) bool {
+	for _, item := range list {
+		if item == i {
+			return true
+		}
+	}
+
+	return false
+}
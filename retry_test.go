@@ -0,0 +1,251 @@
+package server_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// trackingReader records whether the client sent the request body.
+type trackingReader struct {
+	io.Reader
+	read bool
+}
+
+func (r *trackingReader) Read(p []byte) (int, error) {
+	r.read = true
+	return r.Reader.Read(p)
+}
+
+var _ = Describe("retry middleware", func() {
+	var (
+		ts       *httptest.Server
+		srv      *srvPkg.Server
+		failures int
+		calls    int
+		spooled  []string
+		code     int
+		body     string
+	)
+
+	BeforeEach(func() {
+		calls = 0
+
+		ts = test.NewServer(nil)
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		retry := srvPkg.NewRetryMiddleware(srvPkg.RetryOptions{
+			Attempts: 3,
+			Backoff:  time.Millisecond,
+		})
+		upstream := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			calls++
+
+			content, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				return err
+			}
+
+			spooled, _ = filepath.Glob(filepath.Join(os.TempDir(), "middleware-server-buffer-*"))
+
+			if calls <= failures {
+				return ctx.Response.PlainText("unavailable "+string(content), http.StatusServiceUnavailable)
+			}
+			return ctx.Response.PlainText("OK "+string(content), http.StatusOK)
+		}
+		auth := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			if req.Header.Get("Authorization") == "" {
+				return ctx.Response.Unauthorized("Basic")
+			}
+			return ctx.Next()
+		}
+		srv.Serve("PUT", "/upstream", retry, upstream)
+		srv.Serve("POST", "/upstream", retry, upstream)
+		srv.Serve("PUT", "/upload", retry, auth, upstream)
+
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	request := func(method string) {
+		req, err := http.NewRequest(method, ts.URL+"/upstream", strings.NewReader("data"))
+		Expect(err).To(BeNil())
+
+		var res *http.Response
+		res, body = test.ProcessRequest(req)
+		code = res.StatusCode
+	}
+
+	It("should retry idempotent requests replaying the body", func() {
+		failures = 2
+		request("PUT")
+
+		Expect(calls).To(Equal(3))
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("OK data"))
+	})
+
+	It("should respond the last response when the attempts are exhausted", func() {
+		failures = 5
+		request("PUT")
+
+		Expect(calls).To(Equal(3))
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(body).To(Equal("unavailable data"))
+	})
+
+	It("should not retry non-idempotent requests", func() {
+		failures = 1
+		request("POST")
+
+		Expect(calls).To(Equal(1))
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	Context("Response buffer limit", func() {
+		var tmpDir, origTmpDir string
+
+		BeforeEach(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "retry-test")
+			Expect(err).To(BeNil())
+
+			origTmpDir = os.Getenv("TMPDIR")
+			os.Setenv("TMPDIR", tmpDir)
+
+			srv.SetResponseBufferLimit(4)
+		})
+
+		AfterEach(func() {
+			os.Setenv("TMPDIR", origTmpDir)
+			os.RemoveAll(tmpDir)
+		})
+
+		It("should spool large responses to temporary files and remove them", func() {
+			failures = 1
+			request("PUT")
+
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("OK data"))
+
+			// The response of the first attempt was spooled while the second one
+			// ran.
+			Expect(spooled).To(HaveLen(1))
+
+			Eventually(func() []os.FileInfo {
+				files, _ := ioutil.ReadDir(tmpDir)
+				return files
+			}).Should(BeEmpty())
+		})
+	})
+
+	Context("Reverse proxy", func() {
+		var upstream *httptest.Server
+
+		BeforeEach(func() {
+			upstreamCalls := 0
+			upstream = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				upstreamCalls++
+
+				content, err := ioutil.ReadAll(req.Body)
+				if err != nil {
+					http.Error(res, err.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				if upstreamCalls == 1 {
+					res.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				res.Write([]byte("upstream " + string(content)))
+			}))
+
+			target, err := url.Parse(upstream.URL)
+			Expect(err).To(BeNil())
+
+			srv.Serve("PUT", "/proxy", srvPkg.NewRetryMiddleware(srvPkg.RetryOptions{
+				Attempts: 3,
+				Backoff:  time.Millisecond,
+			}), srvPkg.NewReverseProxyMiddleware(target, srvPkg.ReverseProxyOptions{}))
+		})
+
+		AfterEach(func() {
+			upstream.Close()
+		})
+
+		It("should replay the body to the upstream of every attempt", func() {
+			req, err := http.NewRequest("PUT", ts.URL+"/proxy", strings.NewReader("data"))
+			Expect(err).To(BeNil())
+
+			res, body := test.ProcessRequest(req)
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("upstream data"))
+		})
+	})
+
+	Context("Expect: 100-continue", func() {
+		var (
+			client *http.Client
+			reader *trackingReader
+		)
+
+		request := func(authorization string) *http.Response {
+			reader = &trackingReader{Reader: strings.NewReader("data")}
+
+			req, err := http.NewRequest("PUT", ts.URL+"/upload", reader)
+			Expect(err).To(BeNil())
+			req.ContentLength = 4
+			req.Header.Set("Expect", "100-continue")
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+
+			res, err := client.Do(req)
+			Expect(err).To(BeNil())
+			res.Body.Close()
+
+			return res
+		}
+
+		BeforeEach(func() {
+			failures = 0
+
+			client = &http.Client{
+				Transport: &http.Transport{ExpectContinueTimeout: 5 * time.Second},
+			}
+		})
+
+		It("should reject the request before the client sends the body", func() {
+			res := request("")
+
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(reader.read).To(BeFalse())
+		})
+
+		It("should read the body of accepted requests", func() {
+			res := request("Basic Zm9vOmJhcg==")
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(reader.read).To(BeTrue())
+		})
+	})
+})
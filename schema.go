@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/juju/errgo"
+)
+
+// SchemaValidator validates JSON documents against JSON schemas. It is
+// configured using SetSchemaValidator, so the server does not depend on a
+// particular schema library. The subpackage jsonschema provides an
+// implementation.
+type SchemaValidator interface {
+	// Validate returns the violations of the given schema found in the given
+	// document. An error is returned if the schema or document can't be
+	// processed at all.
+	Validate(schema, document []byte) ([]string, error)
+}
+
+// DecodeJSONSchema reads the request body, validates it against the given
+// JSON schema and unmarshals it into v. If the body is not valid JSON or
+// violates the schema, an error with the cause InvalidBodyError is returned,
+// whose message lists the violations. Bodies exceeding 4MB are rejected the
+// same way. Returning this error from a middleware responds with
+// http.StatusBadRequest.
+func (c *Context) DecodeJSONSchema(schema []byte, v interface{}) error {
+	if c.server.schemaValidator == nil {
+		return errgo.New("no schema validator configured")
+	}
+
+	document, err := ioutil.ReadAll(io.LimitReader(c.req.Body, maxSchemaBodyBytes+1))
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if len(document) > maxSchemaBodyBytes {
+		return errgo.WithCausef(nil, InvalidBodyError, "invalid body: exceeds %d bytes", maxSchemaBodyBytes)
+	}
+
+	if !json.Valid(document) {
+		return errgo.WithCausef(nil, InvalidBodyError, "invalid body: malformed JSON")
+	}
+
+	violations, err := c.server.schemaValidator.Validate(schema, document)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if len(violations) > 0 {
+		return errgo.WithCausef(nil, InvalidBodyError, "invalid body: %s", strings.Join(violations, "; "))
+	}
+
+	if err := json.Unmarshal(document, v); err != nil {
+		return errgo.WithCausef(err, InvalidBodyError, "invalid body: %s", err.Error())
+	}
+
+	return nil
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// maxSchemaBodyBytes is the maximum size of a request body read by
+// DecodeJSONSchema.
+const maxSchemaBodyBytes = 4 << 20
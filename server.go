@@ -1,11 +1,16 @@
 package server
 
 import (
+	"crypto/tls"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"runtime/debug"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -21,12 +26,61 @@ const (
 	DefaultOsExitDelay        = 5
 	DefaultOsExitCode         = 0
 
+	// DefaultResponseBufferLimit is the number of bytes of a response body
+	// buffered in memory, e.g. by the retry middleware, before it is spooled
+	// to a temporary file.
+	DefaultResponseBufferLimit = 4 << 20
+
 	RequestIDKey    = "request-id"
 	RequestIDHeader = "X-Request-ID"
 )
 
 type CtxConstructor func() interface{}
 
+// ErrorHandler responds with an error returned by a middleware, see
+// SetErrorHandler. code is the status code the error maps to, e.g.
+// http.StatusBadRequest for errors with the cause InvalidQueryError.
+type ErrorHandler func(res http.ResponseWriter, req *http.Request, ctx *Context, err error, code int)
+
+// PartialResponsePolicy defines how an error returned by a middleware is
+// handled, when the middleware already wrote (parts of) the response. The
+// status code cannot be changed at this point anymore.
+type PartialResponsePolicy int
+
+const (
+	// PartialResponseLogOnly logs the error and keeps the response as written.
+	// This is the default.
+	PartialResponseLogOnly PartialResponsePolicy = iota
+
+	// PartialResponseAppendError logs the error and appends the error message
+	// to the response body.
+	PartialResponseAppendError
+
+	// PartialResponseIgnore neither logs the error nor touches the response.
+	PartialResponseIgnore
+)
+
+// ErrorLogFormat defines how errors returned by middlewares are logged.
+type ErrorLogFormat int
+
+const (
+	// ErrorLogVerbose logs the request, the failing middleware and the error
+	// including the locations it was passed through, as recorded by errgo.
+	// This is the default.
+	ErrorLogVerbose ErrorLogFormat = iota
+
+	// ErrorLogConcise logs the request, the failing middleware and the error
+	// message in a single line.
+	ErrorLogConcise
+)
+
+// RouteMeta holds arbitrary metadata attached to a route. See ServeWithMeta().
+type RouteMeta map[string]interface{}
+
+// ContentTypeMetaKey is the route metadata key holding the Content-Type set
+// for responses of the route before the middlewares run, see ServeContentType.
+const ContentTypeMetaKey = "content-type"
+
 // Middleware is a http handler method.
 type Middleware func(res http.ResponseWriter, req *http.Request, ctx *Context) error
 
@@ -35,19 +89,69 @@ type Context struct {
 	// Contains all placeholders from the route.
 	MuxVars map[string]string
 
+	// Contains the parsed query of the request. Gets filled by ParseQuery.
+	Query url.Values
+
+	// Contains the validated and coerced placeholders from the route. Gets
+	// filled by the params middleware.
+	Params map[string]interface{}
+
+	// Contains the origin of the client. Gets filled by the geo IP
+	// middleware.
+	Geo GeoInfo
+
+	// Contains the API version requested by the client. Gets filled by the
+	// API version middleware.
+	APIVersion string
+
 	// Helper to quickly write results to the `http.ResponseWriter`.
 	Response Response
 
 	// A middleware should call Next() to signal that no problem was encountered
 	// and the next middleware in the chain can be executed after this middleware
 	// finished. Always returns `nil`, so it can be convieniently used with
-	// return to quit the middleware.
+	// return to quit the middleware. See also Proceed().
 	Next func() error
 
 	// The app context for this request. Gets prefilled by the
 	// CtxConstructor, if set in the server.
 	App     interface{}
 	Request requestcontext.Ctx
+
+	req       *http.Request
+	recorder  *responseRecorder
+	body      *bodyCounter
+	routeMeta RouteMeta
+	server    *Server
+	defers    []func() error
+
+	// The response buffers the response is currently written to, the
+	// innermost last. See bufferResponse.
+	buffers []*responseBuffer
+
+	// The middlewares of the current route and the index of the middleware
+	// to be executed next.
+	middlewares []Middleware
+	next        int
+
+	// The index of the middleware currently executed, the index of the
+	// middleware that first returned an error, and the names attached using
+	// NamedMiddleware.
+	running int
+	failed  int
+	names   map[int]string
+
+	// The metrics added using AddTiming, and the durations of the
+	// middlewares, if middleware timing is enabled.
+	timings           []Timing
+	middlewareTimings []Timing
+
+	// The summed durations of the middlewares called by the middleware
+	// currently executed. See runChain.
+	calleeDuration time.Duration
+
+	// The transaction begun by the transaction middleware.
+	tx Tx
 }
 
 // RequestID returns ID for the current request.
@@ -70,28 +174,92 @@ func (c *Context) SetRequestID(ID string) {
 
 type Server struct {
 	// The address to listen on.
-	addr                string
-	logLevel            string
-	logColor            bool
-	Logger              requestcontext.Logger
-	listener            net.Listener
-	extendAccessLogging bool
+	addr     string
+	logLevel string
+	logColor bool
+	Logger   requestcontext.Logger
+	listener net.Listener
 
+	accessReporter  AccessReporterFactory
 	preHTTPHandler  AccessReporter
 	postHTTPHandler AccessReporter
 
 	alreadyRegisteredRoutes bool
+	handlerOnce             sync.Once
+	handler                 http.Handler
 
 	Router *mux.Router
 
 	ctxConstructor CtxConstructor
+	lazyAppContext bool
 
+	started            uint32
+	ready              chan struct{}
+	draining           uint32
 	signalCounter      uint32
 	closeListenerDelay time.Duration
 	osExitDelay        time.Duration
 	osExitCode         int
 
 	IDFactory func() string
+
+	stats *serverStats
+
+	assetMounts []assetMount
+
+	// Global middlewares executed in front of every route. See Use().
+	middlewares []Middleware
+
+	trustedProxyHops int
+
+	partialResponsePolicy PartialResponsePolicy
+	errorLogFormat        ErrorLogFormat
+
+	schemaValidator SchemaValidator
+
+	connStateHook func(net.Conn, http.ConnState)
+
+	traceEnabled bool
+
+	defaultContentType string
+
+	responseBufferLimit int64
+
+	tlsConfig *tls.Config
+
+	errorHandler ErrorHandler
+
+	// The path prefix the routes are mounted at by Handler. See SetBasePath.
+	basePath string
+
+	readHeaderTimeout time.Duration
+
+	middlewareTiming bool
+
+	// The not found handler and the fallbacks in front of it. See
+	// ServeFallback.
+	notFound  http.Handler
+	fallbacks []fallbackRoute
+
+	// The middleware answering requests matching the path of a route, but
+	// none of its methods, if set using SetMethodNotAllowedResponse, and the
+	// handler running it behind the global middlewares.
+	methodNotAllowed        Middleware
+	methodNotAllowedHandler http.Handler
+
+	// The metadata of the routes registered using ServeWithMeta, used to
+	// answer CORS preflight requests of routes without an OPTIONS route.
+	routeMeta map[*mux.Route]RouteMeta
+
+	// The maximum number of middlewares per route, and the number of
+	// middlewares of the longest route registered so far.
+	maxChainLength int
+	longestChain   int
+
+	// The current *maintenanceState, replaced by writers holding the mutex.
+	// See SetMaintenanceMode.
+	maintenance      atomic.Value
+	maintenanceMutex sync.Mutex
 }
 
 func NewServer(host, port string) *Server {
@@ -104,23 +272,126 @@ func NewServer(host, port string) *Server {
 		Router:    router,
 		IDFactory: NewIDFactory(),
 		logColor:  true,
+		stats:     newServerStats(),
+		ready:     make(chan struct{}),
+		routeMeta: map[*mux.Route]RouteMeta{},
 	}
+	router.MethodNotAllowedHandler = http.HandlerFunc(s.serveMethodNotAllowed)
 
+	s.SetAccessReporter(DefaultAccessReporter)
 	s.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "server", Color: s.logColor}))
 	s.SetCloseListenerDelay(DefaultCloseListenerDelay)
 	s.SetOsExitDelay(DefaultOsExitDelay)
 	s.SetOsExitCode(DefaultOsExitCode)
+	s.SetResponseBufferLimit(DefaultResponseBufferLimit)
 
 	return s
 }
 
 func (s *Server) Serve(method, urlPath string, middlewares ...Middleware) {
+	s.ServeWithMeta(method, urlPath, nil, middlewares...)
+}
+
+// ServeWithMeta registers the middlewares like Serve does, and attaches the
+// given metadata to the route. Middlewares can read it using
+// `ctx.RouteMeta()`, which allows global middlewares to behave differently per
+// route.
+// Example: s.ServeWithMeta("GET", "/v1/users", server.RouteMeta{server.CORSMetaKey: corsOptions}, handler)
+func (s *Server) ServeWithMeta(method, urlPath string, meta RouteMeta, middlewares ...Middleware) {
+	if len(middlewares) == 0 {
+		panic("Missing at least one Middleware-Handler.")
+	}
+	s.checkChainLength(method+" "+urlPath, middlewares)
+	if options, ok := meta[CORSMetaKey].(CORSOptions); ok {
+		options.validate()
+	}
+	handler := s.newMiddlewareHandler(meta, middlewares)
+
+	route := s.Router.Methods(method).Path(urlPath).Handler(handler).Name(method + " " + urlPath)
+	s.routeMeta[route] = meta
+}
+
+// ServeSilent registers middlewares like Serve, but requests to the route are
+// not access logged. Use it for noisy endpoints like health checks. It is a
+// shortcut for setting the route metadata AccessLogMetaKey to false.
+func (s *Server) ServeSilent(method, urlPath string, middlewares ...Middleware) {
+	s.ServeWithMeta(method, urlPath, RouteMeta{AccessLogMetaKey: false}, middlewares...)
+}
+
+// ServeContentType registers middlewares like Serve, but responses of the
+// route have the given Content-Type, unless a middleware sets another one.
+// Plain text error responses are still labelled as such. It is a shortcut for
+// setting the route metadata ContentTypeMetaKey.
+// Example: s.ServeContentType("GET", "/v1/report.csv", "text/csv", report)
+func (s *Server) ServeContentType(method, urlPath, contentType string, middlewares ...Middleware) {
+	s.ServeWithMeta(method, urlPath, RouteMeta{ContentTypeMetaKey: contentType}, middlewares...)
+}
+
+// ServeConcurrencyLimited registers middlewares like Serve, but limits the
+// number of requests of the route processed at the same time to max. Further
+// requests are responded with http.StatusServiceUnavailable right away. The
+// limit is independent of other routes, which protects expensive routes
+// without throttling the whole server.
+// Example: s.ServeConcurrencyLimited("POST", "/v1/reports", 4, generateReport)
+func (s *Server) ServeConcurrencyLimited(method, urlPath string, max int, middlewares ...Middleware) {
+	if max <= 0 {
+		panic(fmt.Sprintf("Invalid concurrency limit %d for %s %s. Aborting...", max, method, urlPath))
+	}
+
+	s.Serve(method, urlPath, append([]Middleware{newConcurrencyLimit(max)}, middlewares...)...)
+}
+
+// ServeMatch registers middlewares like Serve, but the route only matches
+// requests the given matcher returns true for. Routes are matched in the
+// order they are registered, so a route without matcher serving the same
+// method and path must be registered last to act as fallback.
+// Example: s.ServeMatch(isPremium, "GET", "/v1/report", premiumReport)
+func (s *Server) ServeMatch(matcher func(*http.Request) bool, method, urlPath string, middlewares ...Middleware) {
 	if len(middlewares) == 0 {
 		panic("Missing at least one Middleware-Handler.")
 	}
-	handler := s.NewMiddlewareHandler(middlewares)
+	s.checkChainLength(method+" "+urlPath, middlewares)
+	handler := s.newMiddlewareHandler(nil, middlewares)
 
-	s.Router.Methods(method).Path(urlPath).Handler(handler).Name(method + " " + urlPath)
+	matcherFunc := func(req *http.Request, match *mux.RouteMatch) bool {
+		return matcher(req)
+	}
+	s.Router.Methods(method).Path(urlPath).MatcherFunc(matcherFunc).Handler(handler).Name(method + " " + urlPath)
+}
+
+// Use registers middlewares that are executed in front of the middlewares of
+// every route served by the server, including the not found handler. If no
+// not found handler was registered using ServeNotFound, a default one
+// responding with http.StatusNotFound is registered, so 404 responses run
+// through the global middlewares as well.
+func (s *Server) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+
+	if s.Router.NotFoundHandler == nil || (s.notFound == nil && len(s.fallbacks) > 0) {
+		s.setNotFoundHandler(s.NewMiddlewareHandler([]Middleware{notFound}))
+	}
+
+	if s.maxChainLength > 0 && len(s.middlewares)+s.longestChain > s.maxChainLength {
+		panic(fmt.Sprintf("Global middlewares exceed the maximum chain length of %d.", s.maxChainLength))
+	}
+}
+
+// UseForMethods registers global middlewares like Use, which are only
+// executed for requests with one of the given methods. For other requests they
+// are skipped, as if they called `ctx.Next()`.
+// Example: s.UseForMethods([]string{"POST", "PUT", "PATCH", "DELETE"}, csrf)
+func (s *Server) UseForMethods(methods []string, middlewares ...Middleware) {
+	upper := make([]string, len(methods))
+	for i, method := range methods {
+		upper[i] = strings.ToUpper(method)
+	}
+
+	filtered := make([]Middleware, len(middlewares))
+	for i, middleware := range middlewares {
+		filtered[i] = forMethods(upper, middleware)
+	}
+
+	s.Use(filtered...)
 }
 
 // ServeStatis registers a middleware that serves files from the filesystem.
@@ -134,13 +405,14 @@ func (s *Server) ServeNotFound(middlewares ...Middleware) {
 	if len(middlewares) == 0 {
 		panic("Missing at least one NotFound-Handler. Aborting...")
 	}
+	s.checkChainLength("not found handler", middlewares)
 
-	s.Router.NotFoundHandler = s.NewMiddlewareHandler(middlewares)
+	s.setNotFoundHandler(s.NewMiddlewareHandler(middlewares))
 }
 
 // ExtendAccessLogging turns on the usage of ExtendedAccessLogger
 func (s *Server) ExtendAccessLogging() {
-	s.extendAccessLogging = true
+	s.SetAccessReporter(ExtendedAccessReporter)
 }
 
 func (s *Server) RegisterRoutes(mux *http.ServeMux, prefix string) {
@@ -161,17 +433,36 @@ func (s *Server) RegisterRoutes(mux *http.ServeMux, prefix string) {
 	s.alreadyRegisteredRoutes = true
 }
 
-func (s *Server) Listen() {
-	mux := http.NewServeMux()
-	s.RegisterRoutes(mux, "/")
+// Listen starts serving the registered routes and blocks until the process
+// is shut down. Calling Listen on a server that is already started returns an
+// error with the cause AlreadyStartedError.
+func (s *Server) Listen() error {
+	if !atomic.CompareAndSwapUint32(&s.started, 0, 1) {
+		return errgo.WithCausef(nil, AlreadyStartedError, "server already started")
+	}
+
+	handler := s.Handler()
 
 	var err error
 	if s.listener, err = net.Listen("tcp", s.addr); err != nil {
 		panic(err)
 	}
+	if s.tlsConfig != nil {
+		s.listener = tls.NewListener(s.listener, s.tlsConfig)
+	}
+	close(s.ready)
 
 	go func() {
-		if err := http.Serve(s.listener, mux); err != nil {
+		server := &http.Server{
+			Handler:           handler,
+			ConnState:         s.connState,
+			ReadHeaderTimeout: s.readHeaderTimeout,
+
+			// Let the dispatcher answer `OPTIONS *` with the methods of the routes.
+			DisableGeneralOptionsHandler: true,
+		}
+
+		if err := server.Serve(s.listener); err != nil {
 			if _, ok := err.(*net.OpError); ok {
 				// We ignore the error "use of closed network connection", because it is
 				// caused by us when shutting down the server.
@@ -182,6 +473,24 @@ func (s *Server) Listen() {
 	}()
 
 	s.listenSignals()
+
+	return nil
+}
+
+// connState tracks the state changes of the connections accepted by Listen
+// and calls the configured hook.
+func (s *Server) connState(conn net.Conn, state http.ConnState) {
+	s.stats.trackConnState(conn, state)
+
+	if s.connStateHook != nil {
+		s.connStateHook(conn, state)
+	}
+}
+
+// Started returns a channel that is closed once Listen bound the listener, so
+// the server accepts connections.
+func (s *Server) Started() <-chan struct{} {
+	return s.ready
 }
 
 func (s *Server) listenSignals() {
@@ -206,12 +515,43 @@ func (s *Server) Closing() bool {
 	return s.signalCounter > 0
 }
 
+// BeginDraining makes the readiness endpoint registered using ServeReady
+// respond with 503, so load balancers stop sending new requests. In contrast to
+// Close, the server keeps serving all requests it receives. Close begins
+// draining as well, so the close listener delay acts as lameduck period.
+func (s *Server) BeginDraining() {
+	if atomic.CompareAndSwapUint32(&s.draining, 0, 1) {
+		s.Logger.Info(nil, "server begins draining")
+	}
+}
+
+// Draining returns true once BeginDraining or Close was called.
+func (s *Server) Draining() bool {
+	return atomic.LoadUint32(&s.draining) == 1
+}
+
+// ServeReady registers a readiness endpoint. It responds with 200 while the
+// server accepts new requests and with 503 once it is draining. Requests to it
+// are not access logged.
+// Example: s.ServeReady("/ready")
+func (s *Server) ServeReady(urlPath string) {
+	s.ServeSilent("GET", urlPath, func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		if s.Draining() {
+			return ctx.Response.PlainText("draining", http.StatusServiceUnavailable)
+		}
+
+		return ctx.Response.PlainText("ready", http.StatusOK)
+	})
+}
+
 func (s *Server) Close() {
 	// Interrupt the process when closing is requested twice.
 	if atomic.AddUint32(&s.signalCounter, 1) >= 2 {
 		s.ExitProcess()
 	}
 
+	s.BeginDraining()
+
 	s.Logger.Info(nil, "closing tcp listener in %s", s.closeListenerDelay.String())
 	time.Sleep(s.closeListenerDelay)
 	s.listener.Close()
@@ -233,6 +573,14 @@ func (s *Server) ExitProcess() {
 // can just write to the `http.ResponseWriter` or use the `ctx.Response` for
 // convienience.
 func (s *Server) NewMiddlewareHandler(middlewares []Middleware) http.Handler {
+	return s.newMiddlewareHandler(nil, middlewares)
+}
+
+func (s *Server) newMiddlewareHandler(meta RouteMeta, middlewares []Middleware) http.Handler {
+	if meta == nil {
+		meta = RouteMeta{}
+	}
+
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		// prepare request
 		requestID := req.Header.Get(RequestIDHeader)
@@ -255,45 +603,68 @@ func (s *Server) NewMiddlewareHandler(middlewares []Middleware) http.Handler {
 			RequestIDKey: requestID,
 		}
 
+		// The context of the request, once created, to report its timings.
+		var ctx *Context
+
 		// create handler that actually processes the middlewares
 		middlewareHandler := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-			ctx := &Context{
+			s.stats.requests.Add(1)
+			s.stats.inFlight.Add(1)
+			defer s.stats.inFlight.Add(-1)
+
+			recorder := &responseRecorder{ResponseWriter: res, defaultContentType: s.defaultContentType}
+			if contentType, ok := meta[ContentTypeMetaKey].(string); ok {
+				res.Header().Set("Content-Type", contentType)
+			}
+
+			body := &bodyCounter{ReadCloser: req.Body}
+			if req.Body != nil {
+				req.Body = body
+			}
+
+			ctx = &Context{
 				MuxVars: mux.Vars(req),
 				Request: requestCtx,
 				Response: Response{
-					w: res,
+					w: recorder,
 				},
+				req:         req,
+				recorder:    recorder,
+				body:        body,
+				routeMeta:   meta,
+				server:      s,
+				middlewares: append(append([]Middleware{}, s.middlewares...), middlewares...),
+				failed:      -1,
 			}
 
-			if s.ctxConstructor != nil {
+			if s.ctxConstructor != nil && !s.lazyAppContext {
 				ctx.App = s.ctxConstructor()
 			}
 
-			for _, middleware := range middlewares {
-				nextCalled := false
-				ctx.Next = func() error {
-					nextCalled = true
-					return nil
+			defer func() {
+				if err := ctx.runDefers(); err != nil {
+					s.handleError(ctx, err)
 				}
+			}()
 
-				// End the request with an error and stop calling further middlewares.
-				if err := middleware(res, req, ctx); err != nil {
-					s.Logger.Error(requestCtx, "%s %s %#v", req.Method, req.URL, errgo.Mask(err))
-
-					ctx.Response.Error(err.Error(), http.StatusInternalServerError)
-					break
-				}
-
-				if !nextCalled {
-					break
-				}
+			// End the request with an error, if any middleware returned one.
+			if err := s.runChain(ctx); err != nil && !isHandled(err) {
+				s.handleError(ctx, err)
 			}
 		})
 
 		// do access-logging by wrapping the middleware handler
-		reporter := DefaultAccessReporter(requestCtx, s.Logger)
-		if s.extendAccessLogging {
-			reporter = ExtendedAccessReporter(requestCtx, s.Logger)
+		reporter := s.accessReporter(requestCtx, s.Logger)
+		if silent, ok := meta[AccessLogMetaKey].(bool); ok && !silent {
+			reporter = func(entry *AccessEntry) {}
+		}
+		report := reporter
+		reporter = func(entry *AccessEntry) {
+			entry.clientIP = s.clientIP(entry.request)
+			if ctx != nil {
+				entry.timings = append(append([]Timing{}, ctx.middlewareTimings...), ctx.timings...)
+			}
+			report(entry)
 		}
 
 		handler := NewLogAccessHandler(
@@ -307,3 +678,123 @@ func (s *Server) NewMiddlewareHandler(middlewares []Middleware) http.Handler {
 		handler.ServeHTTP(res, req)
 	})
 }
+
+// checkChainLength panics if the middlewares of a route, together with the
+// global middlewares, exceed the maximum chain length.
+func (s *Server) checkChainLength(route string, middlewares []Middleware) {
+	if len(middlewares) > s.longestChain {
+		s.longestChain = len(middlewares)
+	}
+
+	if s.maxChainLength <= 0 {
+		return
+	}
+
+	if n := len(s.middlewares) + len(middlewares); n > s.maxChainLength {
+		panic(fmt.Sprintf("Middleware chain of %s has %d middlewares, exceeding the maximum of %d.", route, n, s.maxChainLength))
+	}
+}
+
+// handleError logs the given error returned by a middleware and responds with
+// it. If the response was already written, the partial response policy
+// applies.
+func (s *Server) handleError(ctx *Context, err error) {
+	s.stats.errors.Add(1)
+	if name := ctx.ErrorMiddleware(); name != "" {
+		s.stats.middlewareErrors.Add(name, 1)
+	}
+
+	written := ctx.ResponseWritten()
+	if written && s.partialResponsePolicy == PartialResponseIgnore {
+		return
+	}
+
+	if s.errorLogFormat == ErrorLogConcise {
+		s.Logger.Error(ctx.Request, "%s %s (%s) %s", ctx.req.Method, ctx.req.URL, ctx.ErrorMiddleware(), strings.Replace(err.Error(), "\n", " ", -1))
+	} else {
+		s.Logger.Error(ctx.Request, "%s %s (%s) %#v", ctx.req.Method, ctx.req.URL, ctx.ErrorMiddleware(), errgo.Mask(err))
+	}
+
+	switch {
+	case !written && s.errorHandler != nil:
+		s.callErrorHandler(ctx, err, errorStatusCode(err))
+	case !written:
+		ctx.respondError(err.Error(), errorStatusCode(err))
+	case s.partialResponsePolicy == PartialResponseAppendError:
+		ctx.recorder.Write([]byte(err.Error()))
+	}
+}
+
+// callErrorHandler calls the configured error handler. If it panics, the
+// panic is logged and a minimal response is written, if possible.
+func (s *Server) callErrorHandler(ctx *Context, err error, code int) {
+	defer s.recoverResponse(ctx, "error handler")
+
+	s.errorHandler(ctx.recorder, ctx.req, ctx, err, code)
+}
+
+// runChain executes the middlewares of the given context and returns the
+// error of the failing one, if any. A panicking middleware is recovered, see
+// recoverResponse.
+func (s *Server) runChain(ctx *Context) error {
+	defer s.recoverResponse(ctx, "")
+
+	return ctx.runChain()
+}
+
+// recoverResponse recovers a panic of the named code processing the request
+// of the given context. An empty name refers to the running middleware. The
+// panic is logged with its stack trace and http.StatusInternalServerError is
+// responded, if nothing was written yet. It must be deferred directly.
+// http.ErrAbortHandler is panicked again, so net/http aborts the response,
+// e.g. when httputil.ReverseProxy cannot copy the upstream body.
+func (s *Server) recoverResponse(ctx *Context, name string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if r == http.ErrAbortHandler {
+		panic(r)
+	}
+
+	if name == "" {
+		s.stats.errors.Add(1)
+		name = "middleware " + ctx.middlewareName(ctx.running)
+	}
+
+	s.Logger.Error(ctx.Request, "%s %s %s panicked: %v\n%s", ctx.req.Method, ctx.req.URL, name, r, debug.Stack())
+
+	if !ctx.ResponseWritten() {
+		ctx.respondError(http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// newConcurrencyLimit returns a middleware executing the following middlewares
+// for at most max requests at the same time.
+func newConcurrencyLimit(max int) Middleware {
+	semaphore := make(chan struct{}, max)
+
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		select {
+		case semaphore <- struct{}{}:
+		default:
+			return ctx.Fail(http.StatusServiceUnavailable, "concurrency limit reached")
+		}
+		defer func() { <-semaphore }()
+
+		return ctx.Proceed()
+	}
+}
+
+// methodNotAllowed ends the chain of CORS preflight requests not answered by
+// the CORS middleware, like gorilla's default response.
+func methodNotAllowed(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+	res.WriteHeader(http.StatusMethodNotAllowed)
+	return nil
+}
+
+// notFound is the not found handler registered by Use.
+func notFound(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+	http.NotFound(res, req)
+	return nil
+}
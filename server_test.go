@@ -1,13 +1,21 @@
 package server_test
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"time"
 
 	"github.com/giantswarm/middleware-server/test"
 
 	srvPkg "github.com/giantswarm/middleware-server"
-	log "github.com/op/go-logging"
+	"github.com/giantswarm/request-context"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -21,11 +29,11 @@ func TestServer(t *testing.T) {
 
 // Define testing middlewares v1.
 type V1 struct {
-	Logger *log.Logger
+	Logger requestcontext.Logger
 }
 
 func (this *V1) first(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
-	this.Logger.Debug("test message")
+	this.Logger.Debug(nil, "test message")
 	return ctx.Next()
 }
 
@@ -39,11 +47,11 @@ type AppContext struct {
 }
 
 type V2 struct {
-	Logger *log.Logger
+	Logger requestcontext.Logger
 }
 
 func (this *V2) first(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
-	this.Logger.Info("test message")
+	this.Logger.Info(nil, "test message")
 	ctx.App.(*AppContext).Greeting = "hello world"
 	return ctx.Next()
 }
@@ -61,7 +69,7 @@ var _ = Describe("Server", func() {
 		body1  string
 		body2  string
 		srv    *srvPkg.Server
-		logger *log.Logger
+		logger requestcontext.Logger
 	)
 
 	BeforeEach(func() {
@@ -69,7 +77,7 @@ var _ = Describe("Server", func() {
 		ts = test.NewServer(nil)
 
 		// Create app server.
-		logger = srvPkg.NewLogger(srvPkg.LoggerOptions{Name: "test", Level: "info"})
+		logger = requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "info"})
 		srv = srvPkg.NewServer("", "")
 		srv.SetLogger(logger)
 	})
@@ -136,4 +144,1013 @@ var _ = Describe("Server", func() {
 			})
 		})
 	})
+
+	Context("Lazy app context", func() {
+		var constructed int
+
+		BeforeEach(func() {
+			constructed = 0
+			srv.SetLazyAppContext(func() interface{} {
+				constructed++
+				return &AppContext{Greeting: "hello lazy"}
+			})
+
+			srv.Serve("GET", "/v2/lazy/", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				ctx.GetApp()
+				return ctx.Next()
+			}, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText(ctx.GetApp().(*AppContext).Greeting, http.StatusOK)
+			})
+			srv.Serve("GET", "/v2/rejected/", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.Forbidden()
+			})
+
+			ts.Config.Handler = srv.Router
+		})
+
+		It("Should construct the app context once on first access", func() {
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v2/lazy/")
+
+			Expect(body1).To(Equal("hello lazy"))
+			Expect(constructed).To(Equal(1))
+		})
+
+		It("Should not construct the app context if it is not used", func() {
+			code1, _, _ = test.NewGetRequest(ts.URL + "/v2/rejected/")
+
+			Expect(code1).To(Equal(http.StatusForbidden))
+			Expect(constructed).To(Equal(0))
+		})
+	})
+
+	Context("Expvar", func() {
+		var vars map[string]interface{}
+
+		BeforeEach(func() {
+			v1 := &V1{Logger: logger}
+			srv.Serve("GET", "/v1/hello/", v1.first, v1.last)
+			srv.Serve("GET", "/v1/error/", srvPkg.NamedMiddleware("failing", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return errors.New("test error")
+			}))
+			srv.ServeExpvar("/debug/vars")
+
+			ts.Config.Handler = srv.Router
+
+			test.NewGetRequest(ts.URL + "/v1/hello/")
+			test.NewGetRequest(ts.URL + "/v1/error/")
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/debug/vars")
+
+			vars = map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(body1), &vars)).To(Succeed())
+		})
+
+		It("Should respond with status code 200", func() {
+			Expect(code1).To(Equal(http.StatusOK))
+		})
+
+		It("Should respond the standard expvar variables", func() {
+			Expect(vars).To(HaveKey("cmdline"))
+			Expect(vars).To(HaveKey("memstats"))
+		})
+
+		It("Should respond the server statistics", func() {
+			Expect(vars["server"]).To(Equal(map[string]interface{}{
+				"requests":  float64(2),
+				"errors":    float64(1),
+				"in_flight": float64(0),
+				"connections": map[string]interface{}{
+					"new":    float64(0),
+					"active": float64(0),
+					"idle":   float64(0),
+				},
+				"middleware_errors": map[string]interface{}{
+					"failing": float64(1),
+				},
+				"sla_breaches": map[string]interface{}{},
+			}))
+		})
+	})
+
+	Context("Static assets", func() {
+		var res1 *http.Response
+
+		BeforeEach(func() {
+			srv.ServeStaticAssets("/assets", "./example/fileserver/public/", map[string]string{
+				"test.html": "test.abc123.html",
+			})
+
+			ts.Config.Handler = srv.Router
+
+			code1, body1, res1 = test.NewGetRequest(ts.URL + srv.AssetURL("test.html"))
+			code2, body2, _ = test.NewGetRequest(ts.URL + srv.AssetURL("index.html"))
+		})
+
+		It("Should generate fingerprinted URLs", func() {
+			Expect(srv.AssetURL("test.html")).To(Equal("/assets/test.abc123.html"))
+			Expect(srv.AssetURL("index.html")).To(Equal("/assets/index.html"))
+		})
+
+		It("Should serve fingerprinted files with long cache lifetime", func() {
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(ContainSubstring("test.html"))
+			Expect(res1.Header.Get("Cache-Control")).To(Equal(srvPkg.AssetCacheControl))
+		})
+
+		It("Should serve unfingerprinted files", func() {
+			Expect(code2).To(Equal(http.StatusOK))
+			Expect(body2).To(ContainSubstring("Hello World"))
+		})
+	})
+
+	Context("Listen", func() {
+		It("Should return an error when called twice", func() {
+			srv = srvPkg.NewServer("127.0.0.1", "0")
+			srv.SetLogger(logger)
+
+			errs := make(chan error, 2)
+			go func() { errs <- srv.Listen() }()
+			go func() { errs <- srv.Listen() }()
+
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			Expect(srvPkg.IsAlreadyStarted(err)).To(BeTrue())
+			Consistently(errs).ShouldNot(Receive())
+		})
+
+		It("Should signal once the listener is bound", func() {
+			srv = srvPkg.NewServer("127.0.0.1", "0")
+			srv.SetLogger(logger)
+
+			Consistently(srv.Started()).ShouldNot(BeClosed())
+			go srv.Listen()
+			Eventually(srv.Started()).Should(BeClosed())
+		})
+	})
+
+	Context("Read header timeout", func() {
+		It("Should close connections not sending the headers in time", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+			addr := l.Addr().String()
+			Expect(l.Close()).To(Succeed())
+
+			host, port, err := net.SplitHostPort(addr)
+			Expect(err).To(BeNil())
+
+			srv = srvPkg.NewServer(host, port)
+			srv.SetLogger(logger)
+			srv.SetReadHeaderTimeout(100 * time.Millisecond)
+			go srv.Listen()
+			Eventually(srv.Started()).Should(BeClosed())
+
+			conn, err := net.Dial("tcp", addr)
+			Expect(err).To(BeNil())
+			defer conn.Close()
+
+			_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n"))
+			Expect(err).To(BeNil())
+
+			closed := make(chan struct{})
+			go func() {
+				ioutil.ReadAll(conn)
+				close(closed)
+			}()
+
+			Eventually(closed, time.Second).Should(BeClosed())
+		})
+	})
+
+	Context("Connection states", func() {
+		It("Should call the hook and count connections per state", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+			addr := l.Addr().String()
+			Expect(l.Close()).To(Succeed())
+
+			host, port, err := net.SplitHostPort(addr)
+			Expect(err).To(BeNil())
+
+			var mutex sync.Mutex
+			var states []http.ConnState
+
+			srv = srvPkg.NewServer(host, port)
+			srv.SetLogger(logger)
+			srv.SetConnStateHook(func(conn net.Conn, state http.ConnState) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				states = append(states, state)
+			})
+			srv.ServeExpvar("/debug/vars")
+			go srv.Listen()
+			Eventually(srv.Started()).Should(BeClosed())
+
+			code1, body1, _ = test.NewGetRequest("http://" + addr + "/debug/vars")
+			Expect(code1).To(Equal(http.StatusOK))
+
+			vars := map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(body1), &vars)).To(Succeed())
+			Expect(vars["server"].(map[string]interface{})["connections"]).To(HaveKeyWithValue("active", float64(1)))
+
+			Eventually(func() []http.ConnState {
+				mutex.Lock()
+				defer mutex.Unlock()
+				return append([]http.ConnState(nil), states...)
+			}).Should(ContainElement(http.StateIdle))
+		})
+	})
+
+	Context("ServeMatch", func() {
+		BeforeEach(func() {
+			premium := func(req *http.Request) bool {
+				return req.Header.Get("X-Tier") == "premium"
+			}
+			srv.ServeMatch(premium, "GET", "/v1/report", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText("premium report", http.StatusOK)
+			})
+			srv.Serve("GET", "/v1/report", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText("report", http.StatusOK)
+			})
+
+			ts.Config.Handler = srv.Router
+		})
+
+		It("Should route matching requests to the matched route", func() {
+			req := test.Get(ts.URL + "/v1/report")
+			req.Header.Set("X-Tier", "premium")
+			_, body1 = test.ProcessRequest(req)
+
+			Expect(body1).To(Equal("premium report"))
+		})
+
+		It("Should fall back to the route without matcher", func() {
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1/report")
+
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(Equal("report"))
+		})
+	})
+
+	Context("Partial response policy", func() {
+		BeforeEach(func() {
+			srv.Serve("GET", "/v1/partial/", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				res.WriteHeader(http.StatusOK)
+				res.Write([]byte("partial "))
+				return errors.New("test error")
+			})
+			srv.Serve("GET", "/v1/partial-fail/", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				if err := ctx.Response.PlainText("partial ", http.StatusOK); err != nil {
+					return err
+				}
+				return ctx.Fail(http.StatusForbidden, "access denied")
+			})
+
+			ts.Config.Handler = srv.Router
+		})
+
+		It("Should not respond a failure after a written response", func() {
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1/partial-fail/")
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(Equal("partial "))
+
+			srv.SetPartialResponsePolicy(srvPkg.PartialResponseAppendError)
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1/partial-fail/")
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(Equal("partial access denied"))
+		})
+
+		It("Should keep the partial response by default", func() {
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1/partial/")
+
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(Equal("partial "))
+		})
+
+		It("Should append the error when configured", func() {
+			srv.SetPartialResponsePolicy(srvPkg.PartialResponseAppendError)
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1/partial/")
+
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(Equal("partial test error"))
+		})
+
+		It("Should ignore the error when configured", func() {
+			srv.SetPartialResponsePolicy(srvPkg.PartialResponseIgnore)
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1/partial/")
+
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(Equal("partial "))
+		})
+	})
+
+	Context("Access reporter", func() {
+		var entries []*srvPkg.AccessEntry
+
+		BeforeEach(func() {
+			entries = nil
+
+			srv.SetAccessReporter(func(ctx requestcontext.Ctx, logger requestcontext.Logger) srvPkg.AccessReporter {
+				return func(entry *srvPkg.AccessEntry) {
+					entries = append(entries, entry)
+				}
+			})
+
+			v1 := &V1{Logger: logger}
+			srv.Serve("GET", "/v1/hello/", v1.first, v1.last)
+			srv.ServeSilent("GET", "/healthcheck", v1.last)
+
+			ts.Config.Handler = srv.Router
+
+			test.NewGetRequest(ts.URL + "/v1/hello/")
+			test.NewGetRequest(ts.URL + "/healthcheck")
+		})
+
+		It("Should report requests using the configured reporter", func() {
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].RouteName()).To(Equal("GET /v1/hello/"))
+			Expect(entries[0].RouteTemplate()).To(Equal("/v1/hello/"))
+			Expect(entries[0].StatusCode()).To(Equal(http.StatusOK))
+			Expect(entries[0].Size()).To(Equal(int64(len("hello world"))))
+		})
+
+		It("Should report the path template of the matched route", func() {
+			srv.Serve("GET", "/v1/users/{id}", (&V1{Logger: logger}).last)
+			test.NewGetRequest(ts.URL + "/v1/users/42")
+
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[1].RouteTemplate()).To(Equal("/v1/users/{id}"))
+		})
+
+		It("Should report the timings of the request", func() {
+			srv.SetMiddlewareTiming(true)
+
+			auth := srvPkg.NamedMiddleware("auth", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				time.Sleep(20 * time.Millisecond)
+				return ctx.Proceed()
+			})
+			handler := srvPkg.NamedMiddleware("handler", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				time.Sleep(40 * time.Millisecond)
+				ctx.AddTiming("db", 5*time.Millisecond)
+				return ctx.Response.PlainText("timed", http.StatusOK)
+			})
+			srv.Serve("GET", "/v1/timed", auth, handler)
+			test.NewGetRequest(ts.URL + "/v1/timed")
+
+			Expect(entries).To(HaveLen(2))
+			timings := entries[1].Timings()
+			Expect(timings).To(HaveLen(3))
+
+			Expect(timings[0].Name).To(Equal("auth"))
+			Expect(timings[0].Duration).To(BeNumerically("~", 20*time.Millisecond, 15*time.Millisecond))
+			Expect(timings[1].Name).To(Equal("handler"))
+			Expect(timings[1].Duration).To(BeNumerically("~", 40*time.Millisecond, 15*time.Millisecond))
+			Expect(timings[2]).To(Equal(srvPkg.Timing{Name: "db", Duration: 5 * time.Millisecond}))
+		})
+
+		It("Should not time middlewares by default", func() {
+			Expect(entries[0].Timings()).To(BeEmpty())
+		})
+
+		It("Should not report requests to silent routes", func() {
+			for _, entry := range entries {
+				Expect(entry.RouteName()).NotTo(Equal("GET /healthcheck"))
+			}
+		})
+	})
+
+	Context("OPTIONS *", func() {
+		var res *http.Response
+
+		BeforeEach(func() {
+			v1 := &V1{Logger: logger}
+			srv.Serve("GET", "/v1/hello/", v1.first, v1.last)
+			srv.Serve("PUT", "/v1/hello/", v1.first, v1.last)
+
+			// Configure the http.Server like Listen does.
+			optionsServer := httptest.NewUnstartedServer(srv.Handler())
+			optionsServer.Config.DisableGeneralOptionsHandler = true
+			optionsServer.Start()
+			defer optionsServer.Close()
+
+			// http.Client cannot send the asterisk-form, so the request is
+			// written to the connection directly.
+			conn, err := net.Dial("tcp", optionsServer.Listener.Addr().String())
+			Expect(err).To(BeNil())
+			defer conn.Close()
+
+			_, err = conn.Write([]byte("OPTIONS * HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+			Expect(err).To(BeNil())
+
+			res, err = http.ReadResponse(bufio.NewReader(conn), nil)
+			Expect(err).To(BeNil())
+			res.Body.Close()
+		})
+
+		It("Should respond with status code 204", func() {
+			Expect(res.StatusCode).To(Equal(http.StatusNoContent))
+		})
+
+		It("Should respond the methods of all routes", func() {
+			Expect(res.Header.Get("Allow")).To(Equal("GET, OPTIONS, PUT"))
+		})
+	})
+
+	Context("TRACE", func() {
+		trace := func() *http.Response {
+			req := test.Get(ts.URL + "/v1/hello/")
+			req.Method = "TRACE"
+			req.Header.Set("Authorization", "Bearer secret")
+			req.Header.Set("X-Debug", "yes")
+
+			res, body := test.ProcessRequest(req)
+			body1 = body
+			return res
+		}
+
+		BeforeEach(func() {
+			v1 := &V1{Logger: logger}
+			srv.Serve("GET", "/v1/hello/", v1.first, v1.last)
+			srv.Serve("TRACE", "/v1/hello/", v1.first, v1.last)
+
+			ts.Config.Handler = srv.Handler()
+		})
+
+		It("Should respond with status code 405 by default", func() {
+			res := trace()
+
+			Expect(res.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+			Expect(res.Header.Get("Allow")).To(Equal("GET, OPTIONS"))
+		})
+
+		It("Should echo the request without sensitive headers when enabled", func() {
+			srv.EnableTrace()
+			res := trace()
+
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("Content-Type")).To(Equal("message/http"))
+			Expect(body1).To(HavePrefix("TRACE /v1/hello/ HTTP/1.1\r\n"))
+			Expect(body1).To(ContainSubstring("X-Debug: yes\r\n"))
+			Expect(body1).NotTo(ContainSubstring("secret"))
+		})
+
+		It("Should respond with status code 405 when disabled again", func() {
+			srv.EnableTrace()
+			srv.DisableTrace()
+
+			Expect(trace().StatusCode).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
+	Context("Max chain length", func() {
+		var v1 *V1
+
+		BeforeEach(func() {
+			v1 = &V1{Logger: logger}
+			srv.SetMaxChainLength(3)
+		})
+
+		It("Should accept chains within the limit", func() {
+			Expect(func() {
+				srv.Use(v1.first)
+				srv.Serve("GET", "/v1/hello/", v1.first, v1.last)
+			}).NotTo(Panic())
+		})
+
+		It("Should reject routes exceeding the limit", func() {
+			srv.Use(v1.first, v1.first)
+
+			Expect(func() {
+				srv.Serve("GET", "/v1/hello/", v1.first, v1.last)
+			}).To(Panic())
+		})
+
+		It("Should reject global middlewares exceeding the limit", func() {
+			srv.Serve("GET", "/v1/hello/", v1.first, v1.first, v1.last)
+
+			Expect(func() {
+				srv.Use(v1.first)
+			}).To(Panic())
+		})
+	})
+
+	Context("Base path", func() {
+		BeforeEach(func() {
+			srv.SetBasePath("/api/")
+			srv.Serve("GET", "/v1/hello", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText(req.URL.Path+" "+ctx.AbsoluteURL("/v1/hello"), http.StatusOK)
+			})
+			srv.ServeStaticAssets("/assets", "./example/fileserver/public/", map[string]string{})
+
+			ts.Config.Handler = srv.Handler()
+		})
+
+		It("Should serve the routes below the base path", func() {
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/api/v1/hello")
+
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(Equal("/v1/hello " + ts.URL + "/api/v1/hello"))
+		})
+
+		It("Should not serve the routes outside of the base path", func() {
+			code1, _, _ = test.NewGetRequest(ts.URL + "/v1/hello")
+
+			Expect(code1).To(Equal(http.StatusNotFound))
+		})
+
+		It("Should answer paths outside of the base path using the not found handler", func() {
+			srv.Use(func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				res.Header().Set("X-Global", "yes")
+				return ctx.Next()
+			})
+			srv.SetNotFoundResponse(http.StatusNotFound, []byte(`{"error":"not found"}`), "application/json")
+
+			code, body, res := test.NewGetRequest(ts.URL + "/v1/hello")
+
+			Expect(code).To(Equal(http.StatusNotFound))
+			Expect(body).To(Equal(`{"error":"not found"}`))
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(res.Header.Get("X-Global")).To(Equal("yes"))
+		})
+
+		It("Should include the base path in asset URLs", func() {
+			Expect(srv.AssetURL("test.html")).To(Equal("/api/assets/test.html"))
+
+			code1, _, _ = test.NewGetRequest(ts.URL + srv.AssetURL("test.html"))
+			Expect(code1).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("Root path", func() {
+		BeforeEach(func() {
+			srv.ServeNotFound(func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText("custom not found", http.StatusNotFound)
+			})
+
+			ts.Config.Handler = srv.Handler()
+		})
+
+		It("Should respond with the not found handler when / is not served", func() {
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/")
+
+			Expect(code1).To(Equal(http.StatusNotFound))
+			Expect(body1).To(Equal("custom not found"))
+		})
+
+		It("Should respond with the not found handler for unknown top-level paths", func() {
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/unknown/path")
+
+			Expect(code1).To(Equal(http.StatusNotFound))
+			Expect(body1).To(Equal("custom not found"))
+		})
+
+		It("Should respond with the route registered for /", func() {
+			srv.Serve("GET", "/", srvPkg.NewWelcomeMiddleware("test", "0.0.1"))
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/")
+
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(Equal("This is test version 0.0.1\n"))
+		})
+	})
+
+	Context("Global middlewares on not found", func() {
+		BeforeEach(func() {
+			srv.Use(func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				res.Header().Set("X-Global", "yes")
+				return ctx.Next()
+			})
+			srv.Serve("GET", "/v2/hello", (&V1{Logger: logger}).last)
+
+			ts.Config.Handler = srv.Router
+		})
+
+		It("Should run the global middlewares for the default not found handler", func() {
+			res, body := test.ProcessRequest(test.Get(ts.URL + "/v2/missing"))
+
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			Expect(body).To(Equal("404 page not found\n"))
+			Expect(res.Header.Get("X-Global")).To(Equal("yes"))
+		})
+
+		It("Should run the global middlewares for a custom not found handler", func() {
+			srv.ServeNotFound(func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText("custom not found", http.StatusNotFound)
+			})
+			res, body := test.ProcessRequest(test.Get(ts.URL + "/v2/missing"))
+
+			Expect(body).To(Equal("custom not found"))
+			Expect(res.Header.Get("X-Global")).To(Equal("yes"))
+		})
+	})
+
+	Context("Global middlewares for methods", func() {
+		BeforeEach(func() {
+			srv.UseForMethods([]string{"post", "DELETE"}, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				if req.Header.Get("X-CSRF-Token") == "" {
+					return ctx.Response.PlainText("missing csrf token", http.StatusForbidden)
+				}
+				return ctx.Next()
+			})
+
+			handler := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText("ok", http.StatusOK)
+			}
+			srv.Serve("GET", "/v1/items", handler)
+			srv.Serve("POST", "/v1/items", handler)
+
+			ts.Config.Handler = srv.Router
+		})
+
+		It("Should skip the middlewares for other methods", func() {
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1/items")
+
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(Equal("ok"))
+		})
+
+		It("Should run the middlewares for the given methods", func() {
+			code1, body1, _ = test.NewPostRequest(ts.URL+"/v1/items", "", nil)
+			Expect(code1).To(Equal(http.StatusForbidden))
+			Expect(body1).To(Equal("missing csrf token"))
+
+			code1, body1, _ = test.NewPostRequest(ts.URL+"/v1/items", "", map[string]string{"X-CSRF-Token": "abc"})
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(Equal("ok"))
+		})
+	})
+
+	Context("Static not found and method not allowed responses", func() {
+		BeforeEach(func() {
+			srv.Use(func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				res.Header().Set("X-Global", "yes")
+				return ctx.Next()
+			})
+			srv.Serve("GET", "/v1/items", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText("items", http.StatusOK)
+			})
+			srv.SetNotFoundResponse(http.StatusNotFound, []byte(`{"error":"not found"}`), "application/json")
+			srv.SetMethodNotAllowedResponse(http.StatusMethodNotAllowed, []byte(`{"error":"method not allowed"}`), "application/json")
+
+			ts.Config.Handler = srv.Router
+		})
+
+		It("Should respond the not found response", func() {
+			code1, body1, res := test.NewGetRequest(ts.URL + "/v1/missing")
+
+			Expect(code1).To(Equal(http.StatusNotFound))
+			Expect(body1).To(Equal(`{"error":"not found"}`))
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(res.Header.Get("X-Global")).To(Equal("yes"))
+		})
+
+		It("Should respond the method not allowed response", func() {
+			code1, body1, res := test.NewPostRequest(ts.URL+"/v1/items", "", nil)
+
+			Expect(code1).To(Equal(http.StatusMethodNotAllowed))
+			Expect(body1).To(Equal(`{"error":"method not allowed"}`))
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(res.Header.Get("X-Global")).To(Equal("yes"))
+		})
+
+		It("Should apply to routes registered later", func() {
+			srv.Serve("GET", "/v1/later", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText("later", http.StatusOK)
+			})
+
+			code1, body1, _ = test.NewPostRequest(ts.URL+"/v1/later", "", nil)
+			Expect(code1).To(Equal(http.StatusMethodNotAllowed))
+			Expect(body1).To(Equal(`{"error":"method not allowed"}`))
+		})
+	})
+
+	Context("Fallbacks", func() {
+		BeforeEach(func() {
+			respond := func(content string) srvPkg.Middleware {
+				return func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+					return ctx.Response.PlainText(content, http.StatusOK)
+				}
+			}
+
+			srv.ServeFallback("/v1/", respond("v1 fallback"))
+			srv.Serve("GET", "/v1/items", respond("items"))
+			srv.ServeFallback("/v1/legacy", respond("legacy fallback"))
+			srv.ServeNotFound(func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText("custom not found", http.StatusNotFound)
+			})
+
+			ts.Config.Handler = srv.Router
+		})
+
+		It("Should not shadow routes registered later", func() {
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1/items")
+
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(Equal("items"))
+		})
+
+		It("Should handle unmatched paths below the prefix", func() {
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1/unknown/path")
+			Expect(body1).To(Equal("v1 fallback"))
+
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1")
+			Expect(body1).To(Equal("v1 fallback"))
+		})
+
+		It("Should prefer the fallback of the longest prefix", func() {
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1/legacy/users")
+
+			Expect(body1).To(Equal("legacy fallback"))
+		})
+
+		It("Should respond with the not found handler outside of the prefixes", func() {
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v10/items")
+
+			Expect(code1).To(Equal(http.StatusNotFound))
+			Expect(body1).To(Equal("custom not found"))
+		})
+	})
+
+	Context("Default content type", func() {
+		BeforeEach(func() {
+			srv.SetDefaultContentType("application/json")
+			srv.Serve("GET", "/v1/plain", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText(`{"hello": "world"}`, http.StatusOK)
+			})
+			srv.Serve("GET", "/v1/html", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				res.Header().Set("Content-Type", "text/html")
+				_, err := res.Write([]byte("<p>hello world</p>"))
+				return err
+			})
+			srv.Serve("GET", "/v1/empty", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.NoContent()
+			})
+
+			ts.Config.Handler = srv.Router
+		})
+
+		It("Should apply the default to responses without content type", func() {
+			_, _, res := test.NewGetRequest(ts.URL + "/v1/plain")
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/json"))
+		})
+
+		It("Should keep explicitly set content types", func() {
+			_, _, res := test.NewGetRequest(ts.URL + "/v1/html")
+			Expect(res.Header.Get("Content-Type")).To(Equal("text/html"))
+		})
+
+		It("Should not set a content type for responses without body", func() {
+			_, _, res := test.NewGetRequest(ts.URL + "/v1/empty")
+			Expect(res.Header.Get("Content-Type")).To(BeEmpty())
+		})
+	})
+
+	Context("Per route content type", func() {
+		BeforeEach(func() {
+			srv.ServeContentType("GET", "/v1/report.csv", "text/csv", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText("a,b\n1,2\n", http.StatusOK)
+			})
+			srv.ServeContentType("GET", "/v1/report.json", "text/csv", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.Json([]int{1, 2}, http.StatusOK)
+			})
+
+			ts.Config.Handler = srv.Router
+		})
+
+		It("Should set the content type of the route", func() {
+			_, _, res := test.NewGetRequest(ts.URL + "/v1/report.csv")
+			Expect(res.Header.Get("Content-Type")).To(Equal("text/csv"))
+		})
+
+		It("Should let middlewares override the content type", func() {
+			_, _, res := test.NewGetRequest(ts.URL + "/v1/report.json")
+			Expect(res.Header["Content-Type"]).To(Equal([]string{"application/json"}))
+		})
+
+		It("Should not label error responses with the content type of the route", func() {
+			srv.ServeContentType("GET", "/v1/report.broken", "text/csv", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return errors.New("report failed")
+			})
+			srv.ServeContentType("GET", "/v1/report.forbidden", "text/csv", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Fail(http.StatusForbidden, "access denied")
+			})
+
+			code, body, res := test.NewGetRequest(ts.URL + "/v1/report.broken")
+			Expect(code).To(Equal(http.StatusInternalServerError))
+			Expect(body).To(Equal("report failed"))
+			Expect(res.Header.Get("Content-Type")).To(Equal("text/plain; charset=utf-8"))
+
+			code, _, res = test.NewGetRequest(ts.URL + "/v1/report.forbidden")
+			Expect(code).To(Equal(http.StatusForbidden))
+			Expect(res.Header.Get("Content-Type")).To(Equal("text/plain; charset=utf-8"))
+		})
+	})
+
+	Context("Draining", func() {
+		BeforeEach(func() {
+			srv.ServeReady("/ready")
+			srv.Serve("GET", "/v1/hello", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText("hello", http.StatusOK)
+			})
+
+			ts.Config.Handler = srv.Router
+		})
+
+		It("Should respond ready before draining", func() {
+			Expect(srv.Draining()).To(BeFalse())
+
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/ready")
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(Equal("ready"))
+		})
+
+		It("Should respond 503 on the readiness endpoint while draining", func() {
+			srv.BeginDraining()
+			Expect(srv.Draining()).To(BeTrue())
+
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/ready")
+			Expect(code1).To(Equal(http.StatusServiceUnavailable))
+			Expect(body1).To(Equal("draining"))
+		})
+
+		It("Should keep serving other routes while draining", func() {
+			srv.BeginDraining()
+
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1/hello")
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(Equal("hello"))
+		})
+	})
+
+	Context("Common and Combined Log Format", func() {
+		var buf *bytes.Buffer
+
+		BeforeEach(func() {
+			buf = &bytes.Buffer{}
+
+			v1 := &V1{Logger: logger}
+			srv.Serve("GET", "/v1/hello/", v1.first, v1.last)
+		})
+
+		It("Should log requests in Common Log Format", func() {
+			srv.SetAccessReporter(srvPkg.CommonLogAccessReporter(buf))
+			ts.Config.Handler = srv.Router
+
+			req := test.Get(ts.URL + "/v1/hello/?q=1")
+			req.SetBasicAuth("frank", "secret")
+			test.ProcessRequest(req)
+
+			Expect(buf.String()).To(MatchRegexp(`^127\.0\.0\.1 - frank \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /v1/hello/\?q=1 HTTP/1\.1" 200 11\n$`))
+		})
+
+		It("Should log requests in Combined Log Format", func() {
+			srv.SetAccessReporter(srvPkg.CombinedLogAccessReporter(buf))
+			ts.Config.Handler = srv.Router
+
+			req := test.Get(ts.URL + "/v1/hello/")
+			req.Header.Set("Referer", "http://example.com/start")
+			req.Header.Set("User-Agent", `Mozilla/4.08 "quoted"`)
+			test.ProcessRequest(req)
+
+			Expect(buf.String()).To(MatchRegexp(`^127\.0\.0\.1 - - \[[^\]]+\] "GET /v1/hello/ HTTP/1\.1" 200 11 "http://example\.com/start" "Mozilla/4\.08 \\"quoted\\""\n$`))
+		})
+
+		It("Should log the client behind trusted proxies without its port", func() {
+			srv.SetTrustedProxyHops(1)
+			srv.SetAccessReporter(srvPkg.CommonLogAccessReporter(buf))
+			ts.Config.Handler = srv.Router
+
+			req := test.Get(ts.URL + "/v1/hello/")
+			req.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7:4711")
+			test.ProcessRequest(req)
+
+			Expect(buf.String()).To(HavePrefix("203.0.113.7 - - ["))
+		})
+
+		It("Should log a dash for empty responses", func() {
+			srv.Serve("GET", "/v1/empty", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				res.WriteHeader(http.StatusNoContent)
+				return nil
+			})
+			srv.SetAccessReporter(srvPkg.CombinedLogAccessReporter(buf))
+			ts.Config.Handler = srv.Router
+
+			test.ProcessRequest(test.Get(ts.URL + "/v1/empty"))
+
+			Expect(buf.String()).To(HaveSuffix(`" 204 - "-" "Go-http-client/1.1"` + "\n"))
+		})
+	})
+
+	Context("Concurrency limited routes", func() {
+		var started, release chan struct{}
+
+		BeforeEach(func() {
+			started = make(chan struct{}, 2)
+			release = make(chan struct{})
+
+			srv.ServeConcurrencyLimited("GET", "/v1/report", 2, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				started <- struct{}{}
+				<-release
+				return ctx.Response.PlainText("report", http.StatusOK)
+			})
+			srv.Serve("GET", "/v1/hello", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Response.PlainText("hello", http.StatusOK)
+			})
+
+			ts.Config.Handler = srv.Router
+		})
+
+		It("Should respond 503 once the limit of the route is reached", func() {
+			codes := make(chan int, 2)
+			for i := 0; i < 2; i++ {
+				go func() {
+					code, _, _ := test.NewGetRequest(ts.URL + "/v1/report")
+					codes <- code
+				}()
+			}
+
+			Eventually(started).Should(Receive())
+			Eventually(started).Should(Receive())
+
+			code1, _, _ = test.NewGetRequest(ts.URL + "/v1/report")
+			Expect(code1).To(Equal(http.StatusServiceUnavailable))
+
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1/hello")
+			Expect(code1).To(Equal(http.StatusOK))
+
+			close(release)
+			Eventually(codes).Should(Receive(Equal(http.StatusOK)))
+			Eventually(codes).Should(Receive(Equal(http.StatusOK)))
+
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1/report")
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(Equal("report"))
+		})
+
+		It("Should panic for a limit below 1", func() {
+			Expect(func() {
+				srv.ServeConcurrencyLimited("GET", "/v1/none", 0, (&V1{Logger: logger}).last)
+			}).To(Panic())
+		})
+	})
+
+	Context("Version", func() {
+		BeforeEach(func() {
+			srv.ServeVersion("/version", srvPkg.VersionInfo{
+				Version:   "1.2.3",
+				Commit:    "abc123",
+				BuildTime: "2020-05-01T12:00:00Z",
+			})
+
+			ts.Config.Handler = srv.Handler()
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/version")
+		})
+
+		It("Should respond the build information", func() {
+			Expect(code1).To(Equal(http.StatusOK))
+			Expect(body1).To(MatchJSON(`{"version": "1.2.3", "commit": "abc123", "build_time": "2020-05-01T12:00:00Z"}`))
+		})
+	})
+
+	Context("Error handler", func() {
+		BeforeEach(func() {
+			srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+			srv.Serve("GET", "/v1/error/", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return errors.New("test error")
+			})
+
+			ts.Config.Handler = srv.Router
+		})
+
+		It("Should respond using the error handler", func() {
+			srv.SetErrorHandler(func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context, err error, code int) {
+				ctx.Response.Json(map[string]string{"error": err.Error()}, code)
+			})
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1/error/")
+
+			Expect(code1).To(Equal(http.StatusInternalServerError))
+			Expect(body1).To(MatchJSON(`{"error": "test error"}`))
+		})
+
+		It("Should respond to ctx.Fail using the error handler", func() {
+			srv.Serve("GET", "/v1/forbidden/", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+				return ctx.Fail(http.StatusForbidden, "access denied")
+			})
+			srv.SetErrorHandler(func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context, err error, code int) {
+				ctx.Response.Json(map[string]string{"error": err.Error()}, code)
+			})
+			code, body, res := test.NewGetRequest(ts.URL + "/v1/forbidden/")
+
+			Expect(code).To(Equal(http.StatusForbidden))
+			Expect(res.Header.Get("Content-Type")).To(HavePrefix("application/json"))
+			Expect(body).To(MatchJSON(`{"error": "access denied"}`))
+		})
+
+		It("Should respond with status code 500 if the error handler panics", func() {
+			srv.SetErrorHandler(func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context, err error, code int) {
+				panic("broken error handler")
+			})
+			code1, body1, _ = test.NewGetRequest(ts.URL + "/v1/error/")
+
+			Expect(code1).To(Equal(http.StatusInternalServerError))
+			Expect(body1).To(Equal("Internal Server Error"))
+		})
+	})
 })
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Timing is the duration of a named part of the processing of a request.
+type Timing struct {
+	Name     string
+	Duration time.Duration
+}
+
+// AddTiming adds a metric with the given name and duration to the
+// Server-Timing header of the current response, e.g. the time spent querying a
+// database. Metrics are only sent if the route uses the server timing
+// middleware, and only if they are added before the response is written.
+// The metrics are reported in the access log as well, see
+// `AccessEntry.Timings()`.
+// Example: ctx.AddTiming("db", time.Since(start))
+func (c *Context) AddTiming(name string, d time.Duration) {
+	c.timings = append(c.timings, Timing{Name: name, Duration: d})
+}
+
+// NewServerTimingMiddleware provides a middleware that sends the metrics added
+// using `ctx.AddTiming()` and the metric "total" in the Server-Timing header,
+// which browsers show in their developer tools. Since headers cannot be
+// changed once the response is written, "total" is the duration of the
+// following middlewares until the response was written, or until they
+// finished, if they did not write it.
+func NewServerTimingMiddleware() Middleware {
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		orig := ctx.recorder.ResponseWriter
+		tw := &timingWriter{ResponseWriter: orig, ctx: ctx, start: time.Now()}
+
+		ctx.recorder.ResponseWriter = tw
+		defer func() {
+			ctx.recorder.ResponseWriter = orig
+		}()
+
+		err := ctx.Proceed()
+
+		// Set the header for a response written later on, e.g. an error
+		// response.
+		tw.setHeader()
+
+		return err
+	}
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// serverTimingMetric formats the given timing as metric of the Server-Timing
+// header.
+func serverTimingMetric(t Timing) string {
+	return fmt.Sprintf("%s;dur=%.1f", t.Name, float64(t.Duration)/float64(time.Millisecond))
+}
+
+// timingWriter is a http.ResponseWriter that sets the Server-Timing header
+// right before the response is written.
+type timingWriter struct {
+	http.ResponseWriter
+
+	ctx   *Context
+	start time.Time
+	done  bool
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	w.setHeader()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timingWriter) Flush() {
+	w.setHeader()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *timingWriter) setHeader() {
+	if w.done {
+		return
+	}
+	w.done = true
+
+	// Copy the timings, so the total is not written into their backing array.
+	timings := append(append([]Timing{}, w.ctx.timings...), Timing{Name: "total", Duration: time.Since(w.start)})
+
+	metrics := make([]string, len(timings))
+	for i, timing := range timings {
+		metrics[i] = serverTimingMetric(timing)
+	}
+
+	w.Header().Set("Server-Timing", strings.Join(metrics, ", "))
+}
@@ -0,0 +1,66 @@
+package server_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("server timing middleware", func() {
+	var (
+		ts  *httptest.Server
+		srv *srvPkg.Server
+	)
+
+	BeforeEach(func() {
+		logger := requestcontext.MustGetLogger(requestcontext.LoggerConfig{
+			Name:  "test",
+			Level: "critical",
+		})
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(logger)
+
+		timing := srvPkg.NewServerTimingMiddleware()
+		srv.Serve("GET", "/users", timing, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			ctx.AddTiming("db", 12500*time.Microsecond)
+			ctx.AddTiming("cache", 300*time.Microsecond)
+			return ctx.Response.PlainText("users", http.StatusOK)
+		})
+		srv.Serve("GET", "/error", timing, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			ctx.AddTiming("db", 2*time.Millisecond)
+			return errors.New("test error")
+		})
+
+		ts = test.NewServer(nil)
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("should send the added metrics and the total duration", func() {
+		code, body, res := test.NewGetRequest(ts.URL + "/users")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("users"))
+
+		Expect(res.Header.Get("Server-Timing")).To(MatchRegexp(`^db;dur=12\.5, cache;dur=0\.3, total;dur=\d+\.\d$`))
+	})
+
+	It("should send the metrics with error responses", func() {
+		code, _, res := test.NewGetRequest(ts.URL + "/error")
+		Expect(code).To(Equal(http.StatusInternalServerError))
+
+		Expect(res.Header.Get("Server-Timing")).To(MatchRegexp(`^db;dur=2\.0, total;dur=\d+\.\d$`))
+	})
+})
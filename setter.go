@@ -1,6 +1,9 @@
 package server
 
 import (
+	"crypto/tls"
+	"net"
+	"net/http"
 	"time"
 
 	"github.com/giantswarm/request-context"
@@ -14,11 +17,28 @@ func (s *Server) SetPostHTTPHandler(reporter AccessReporter) {
 	s.postHTTPHandler = reporter
 }
 
+// SetAccessReporter sets the factory creating the AccessReporter used to log
+// every request. It defaults to DefaultAccessReporter. This allows servers in
+// the same process to log requests in different formats.
+func (s *Server) SetAccessReporter(factory AccessReporterFactory) {
+	s.accessReporter = factory
+}
+
 // SetAppContext sets the CtxConstructor object, that is called for every
 // request to provide the initial `Context.App` value, which is available to
 // every middleware.
 func (s *Server) SetAppContext(ctxConstructor CtxConstructor) {
 	s.ctxConstructor = ctxConstructor
+	s.lazyAppContext = false
+}
+
+// SetLazyAppContext sets the CtxConstructor like SetAppContext, but it is only
+// called once `ctx.GetApp()` is called for a request. This avoids expensive
+// setup, like starting a transaction, for requests rejected early. Middlewares
+// must use GetApp instead of reading `Context.App` directly then.
+func (s *Server) SetLazyAppContext(ctxConstructor CtxConstructor) {
+	s.ctxConstructor = ctxConstructor
+	s.lazyAppContext = true
 }
 
 func (s *Server) SetLogLevel(level string) {
@@ -51,3 +71,130 @@ func (s *Server) SetOsExitDelay(d int) {
 func (s *Server) SetOsExitCode(c int) {
 	s.osExitCode = c
 }
+
+// SetTrustedProxyHops sets the number of reverse proxies in front of the
+// server, which are trusted to append the address of their client to the
+// X-Forwarded-For header. `ctx.ClientIP()` uses it to pick the client address
+// from the header. Zero, the default, ignores the header.
+func (s *Server) SetTrustedProxyHops(n int) {
+	s.trustedProxyHops = n
+}
+
+// SetPartialResponsePolicy sets how errors returned by middlewares that
+// already wrote the response are handled. Defaults to PartialResponseLogOnly.
+func (s *Server) SetPartialResponsePolicy(policy PartialResponsePolicy) {
+	s.partialResponsePolicy = policy
+}
+
+// SetErrorLogFormat sets how errors returned by middlewares are logged, e.g.
+// ErrorLogConcise in production. Defaults to ErrorLogVerbose.
+func (s *Server) SetErrorLogFormat(format ErrorLogFormat) {
+	s.errorLogFormat = format
+}
+
+// SetMaxChainLength sets the maximum number of middlewares a route may be
+// composed of, including the global middlewares. Registering a route or
+// global middlewares exceeding it panics, which catches accidentally
+// duplicated middlewares in programmatically assembled chains. Zero, the
+// default, disables the check.
+func (s *Server) SetMaxChainLength(n int) {
+	s.maxChainLength = n
+}
+
+// SetSchemaValidator sets the validator used by `ctx.DecodeJSONSchema()`.
+func (s *Server) SetSchemaValidator(v SchemaValidator) {
+	s.schemaValidator = v
+}
+
+// SetConnStateHook sets a function that is called whenever a connection
+// accepted by Listen changes its state, like `http.Server.ConnState`. This
+// helps debugging connection reuse and keep-alive issues.
+func (s *Server) SetConnStateHook(hook func(net.Conn, http.ConnState)) {
+	s.connStateHook = hook
+}
+
+// SetDefaultContentType sets the Content-Type header of responses that don't
+// set one before writing the status code or body, like `ctx.Response.PlainText()`
+// does. Without a default, Go sniffs the content type from the body.
+func (s *Server) SetDefaultContentType(contentType string) {
+	s.defaultContentType = contentType
+}
+
+// SetResponseBufferLimit sets the number of bytes of a response body that
+// middlewares buffering the response, like the retry and idempotency
+// middlewares, keep in memory. Larger bodies are spooled to a temporary file,
+// which is removed once the request is done. Zero keeps all bodies in memory.
+// Defaults to DefaultResponseBufferLimit.
+func (s *Server) SetResponseBufferLimit(n int64) {
+	s.responseBufferLimit = n
+}
+
+// SetTLSConfig makes Listen serve HTTPS using the given configuration, which
+// must contain at least one certificate. To authenticate clients using
+// certificates, configure the CAs the client certificates are verified
+// against, and use `ctx.ClientCertificate()` or NewClientCertMiddleware:
+//
+//	s.SetTLSConfig(&tls.Config{
+//		Certificates: []tls.Certificate{cert},
+//		ClientAuth:   tls.RequireAndVerifyClientCert,
+//		ClientCAs:    clientCAs,
+//	})
+func (s *Server) SetTLSConfig(config *tls.Config) {
+	s.tlsConfig = config
+}
+
+// SetErrorHandler sets the function responding with errors returned by
+// middlewares, e.g. to render them as JSON. It is only called if the response
+// was not written yet, and errors are logged before. If the handler panics,
+// the panic is logged and the request is answered with
+// http.StatusInternalServerError. By default the error message is responded as
+// plain text.
+func (s *Server) SetErrorHandler(handler ErrorHandler) {
+	s.errorHandler = handler
+}
+
+// SetBasePath sets the path prefix Handler, and so Listen, mounts the routes
+// at, e.g. "/api" to serve the route "/v1/users" at "/api/v1/users". The
+// prefix is stripped before the routes are matched. `s.AssetURL()` and
+// `ctx.AbsoluteURL()` include it. It must be set before Handler is called for
+// the first time.
+func (s *Server) SetBasePath(prefix string) {
+	s.basePath = prefix
+}
+
+// SetReadHeaderTimeout sets the time a client connected to a server started
+// using Listen may take to send the request headers, which protects against
+// clients dribbling headers to tie up connections. The server sets no overall
+// read timeout, so reading the request body stays unbounded, e.g. for
+// streaming uploads. For keep-alive connections the timeout also bounds the
+// time until the headers of the next request are sent. Zero, the default,
+// disables it.
+func (s *Server) SetReadHeaderTimeout(d time.Duration) {
+	s.readHeaderTimeout = d
+}
+
+// SetNotFoundResponse makes requests not matching any route being responded
+// with the given status code, body and Content-Type. It is a lightweight
+// alternative to ServeNotFound, which it replaces. Global middlewares are
+// executed in front of it.
+// Example: s.SetNotFoundResponse(http.StatusNotFound, []byte(`{"error":"not found"}`), "application/json")
+func (s *Server) SetNotFoundResponse(status int, body []byte, contentType string) {
+	s.ServeNotFound(staticResponse(status, body, contentType))
+}
+
+// SetMethodNotAllowedResponse makes requests matching the path of a route, but
+// none of its methods, being responded with the given status code, body and
+// Content-Type, instead of an empty http.StatusMethodNotAllowed response.
+// Global middlewares are executed in front of it.
+func (s *Server) SetMethodNotAllowedResponse(status int, body []byte, contentType string) {
+	s.methodNotAllowed = staticResponse(status, body, contentType)
+	s.methodNotAllowedHandler = s.NewMiddlewareHandler([]Middleware{s.methodNotAllowed})
+}
+
+// SetMiddlewareTiming enables measuring the time spent in every middleware,
+// excluding the middlewares it called using `ctx.Proceed()`. The durations are
+// reported in the access log, see `AccessEntry.Timings()`, named as in error
+// logs, see NamedMiddleware. Disabled by default to avoid the overhead.
+func (s *Server) SetMiddlewareTiming(enabled bool) {
+	s.middlewareTiming = enabled
+}
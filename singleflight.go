@@ -0,0 +1,149 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/juju/errgo"
+)
+
+// NewSingleFlightMiddleware provides a middleware that coalesces concurrent
+// requests with the same key, as returned by the given function. Only the
+// first request runs the following middlewares, while the others wait for it
+// and receive a copy of its response, and of its error, if any. If the first
+// request panics, the waiting ones fail with an error. Waiting requests return
+// early when their client goes away. This protects backends from a thundering
+// herd of identical requests, e.g. on a cache miss. Requests for which an
+// empty key is returned are processed as usual. If no key function is given,
+// requests are keyed by method and URL.
+//
+// The key must capture everything the response depends on. Responses that
+// depend on the client, e.g. due to authorization, must not be shared.
+func NewSingleFlightMiddleware(key func(req *http.Request) string) Middleware {
+	if key == nil {
+		key = func(req *http.Request) string {
+			return req.Method + " " + req.URL.String()
+		}
+	}
+
+	group := &flightGroup{
+		calls: map[string]*flightCall{},
+	}
+
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		k := key(req)
+		if k == "" {
+			return ctx.Proceed()
+		}
+
+		call, leader := group.join(k)
+		if !leader {
+			select {
+			case <-call.done:
+				return call.replay(ctx.recorder)
+			case <-req.Context().Done():
+				// The client went away. There is nobody left to respond to.
+				return nil
+			}
+		}
+		defer group.leave(k, call)
+
+		buf, restore := ctx.bufferResponse()
+
+		finished := false
+		defer func() {
+			if finished {
+				return
+			}
+
+			// A following middleware panicked. Restore the response writer, so
+			// the panic can be responded, and let the waiting requests fail
+			// instead of replaying an empty response.
+			restore()
+			call.err = errgo.New("single flight request panicked")
+		}()
+
+		err := ctx.Proceed()
+		finished = true
+		restore()
+
+		call.statusCode = buf.statusCode
+		call.header = buf.header.Clone()
+		call.err = err
+		call.body, call.bodyErr = buf.bytes()
+
+		if flushErr := buf.flushTo(ctx.recorder); flushErr != nil {
+			return errgo.Mask(flushErr)
+		}
+
+		return err
+	}
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// flightGroup tracks the requests in flight per key. It is implemented here,
+// instead of using golang.org/x/sync/singleflight, to avoid a new dependency.
+type flightGroup struct {
+	mutex sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is the result of a request shared with the requests waiting for
+// it. It may only be read once done is closed.
+type flightCall struct {
+	done chan struct{}
+
+	statusCode int
+	header     http.Header
+	body       []byte
+	bodyErr    error
+	err        error
+}
+
+// join returns the call in flight for the given key, or starts a new one, in
+// which case leader is true.
+func (g *flightGroup) join(key string) (call *flightCall, leader bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if call, ok := g.calls[key]; ok {
+		return call, false
+	}
+
+	call = &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+
+	return call, true
+}
+
+// leave finishes the given call, so the waiting requests can read it.
+func (g *flightGroup) leave(key string, call *flightCall) {
+	g.mutex.Lock()
+	delete(g.calls, key)
+	g.mutex.Unlock()
+
+	close(call.done)
+}
+
+// replay writes a copy of the shared response and returns the shared error.
+func (c *flightCall) replay(w http.ResponseWriter) error {
+	if c.bodyErr != nil {
+		return errgo.Mask(c.bodyErr)
+	}
+
+	if c.statusCode != 0 {
+		header := w.Header()
+		for k, v := range c.header {
+			header[k] = append([]string(nil), v...)
+		}
+
+		w.WriteHeader(c.statusCode)
+		if _, err := w.Write(c.body); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+
+	return c.err
+}
@@ -0,0 +1,152 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("single flight middleware", func() {
+	var (
+		ts       *httptest.Server
+		calls    int32
+		keyed    chan struct{}
+		release  chan struct{}
+		finished chan struct{}
+	)
+
+	// key signals every request about to join a flight, so the test can
+	// release the first one once all of them arrived.
+	key := func(req *http.Request) string {
+		keyed <- struct{}{}
+		return req.URL.Path + "?" + req.URL.Query().Get("q")
+	}
+
+	BeforeEach(func() {
+		ts = test.NewServer(nil)
+		calls = 0
+		keyed = make(chan struct{}, 10)
+		release = make(chan struct{})
+		finished = make(chan struct{}, 10)
+
+		srv := srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		track := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			defer func() { finished <- struct{}{} }()
+			return ctx.Proceed()
+		}
+
+		srv.Serve("GET", "/expensive", track, srvPkg.NewSingleFlightMiddleware(key), func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			atomic.AddInt32(&calls, 1)
+			<-release
+
+			res.Header().Set("X-Result", req.URL.Query().Get("q"))
+			return ctx.Response.PlainText("result "+req.URL.Query().Get("q"), http.StatusOK)
+		})
+		srv.Serve("GET", "/panic", srvPkg.NewSingleFlightMiddleware(key), func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			atomic.AddInt32(&calls, 1)
+			<-release
+
+			panic("leader panicked")
+		})
+
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	// request sends n concurrent requests and releases the first one once all
+	// of them are about to join a flight.
+	request := func(path string, n int, query func(i int) string) ([]*http.Response, []string) {
+		var wg sync.WaitGroup
+		responses := make([]*http.Response, n)
+		bodies := make([]string, n)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				responses[i], bodies[i] = test.ProcessRequest(test.Get(ts.URL + path + "?q=" + query(i)))
+			}(i)
+		}
+
+		for i := 0; i < n; i++ {
+			Eventually(keyed).Should(Receive())
+		}
+		close(release)
+		wg.Wait()
+
+		return responses, bodies
+	}
+
+	It("should run the chain once for concurrent identical requests", func() {
+		responses, bodies := request("/expensive", 5, func(i int) string { return "a" })
+
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+		for i, res := range responses {
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("X-Result")).To(Equal("a"))
+			Expect(bodies[i]).To(Equal("result a"))
+		}
+	})
+
+	It("should run the chain per key", func() {
+		_, bodies := request("/expensive", 4, func(i int) string { return []string{"a", "b"}[i%2] })
+
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+		for i, body := range bodies {
+			Expect(body).To(Equal("result " + []string{"a", "b"}[i%2]))
+		}
+	})
+
+	It("should run the chain again once the request finished", func() {
+		close(release)
+		test.NewGetRequest(ts.URL + "/expensive?q=a")
+		test.NewGetRequest(ts.URL + "/expensive?q=a")
+
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+	})
+
+	It("should fail the waiting requests if the first one panics", func() {
+		responses, _ := request("/panic", 3, func(i int) string { return "a" })
+
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+		for _, res := range responses {
+			Expect(res.StatusCode).To(Equal(http.StatusInternalServerError))
+		}
+	})
+
+	It("should stop waiting when the client goes away", func() {
+		go test.NewGetRequest(ts.URL + "/expensive?q=a")
+		Eventually(keyed).Should(Receive())
+		Eventually(func() int32 { return atomic.LoadInt32(&calls) }).Should(Equal(int32(1)))
+
+		reqCtx, cancel := context.WithCancel(context.Background())
+		req := test.Get(ts.URL + "/expensive?q=a").WithContext(reqCtx)
+		go http.DefaultClient.Do(req)
+		Eventually(keyed).Should(Receive())
+
+		cancel()
+
+		// The waiting request finishes while the first one is still running.
+		Eventually(finished, time.Second).Should(Receive())
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+
+		close(release)
+		Eventually(finished).Should(Receive())
+	})
+})
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// NewSLAMonitorMiddleware provides a middleware that measures the duration of
+// the following middlewares. When it exceeds the given target, a warning is
+// logged and the breach is counted per route in the variable "sla_breaches"
+// of the server statistics, see ServeExpvar. The response is not affected. To
+// abort slow requests, see `ctx.SetTimeout()`.
+func NewSLAMonitorMiddleware(target time.Duration) Middleware {
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		start := time.Now()
+		err := ctx.Proceed()
+		duration := time.Since(start)
+
+		if duration > target {
+			route := req.Method + " route-not-found"
+			if current := mux.CurrentRoute(req); current != nil && current.GetName() != "" {
+				route = current.GetName()
+			}
+
+			ctx.server.stats.slaBreaches.Add(route, 1)
+			ctx.server.Logger.Warning(ctx.Request, "%s took %s, exceeding the target of %s", route, duration, target)
+		}
+
+		return err
+	}
+}
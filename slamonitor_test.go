@@ -0,0 +1,81 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SLA monitor middleware", func() {
+	var (
+		ts  *httptest.Server
+		srv *srvPkg.Server
+	)
+
+	breaches := func() map[string]interface{} {
+		_, body, _ := test.NewGetRequest(ts.URL + "/debug/vars")
+
+		vars := struct {
+			Server struct {
+				SLABreaches map[string]interface{} `json:"sla_breaches"`
+			} `json:"server"`
+		}{}
+		Expect(json.Unmarshal([]byte(body), &vars)).To(Succeed())
+
+		return vars.Server.SLABreaches
+	}
+
+	BeforeEach(func() {
+		logger := requestcontext.MustGetLogger(requestcontext.LoggerConfig{
+			Name:  "test",
+			Level: "critical",
+		})
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(logger)
+
+		monitor := srvPkg.NewSLAMonitorMiddleware(20 * time.Millisecond)
+		srv.Serve("GET", "/fast", monitor, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.Response.PlainText("fast", http.StatusOK)
+		})
+		srv.Serve("GET", "/slow", monitor, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			time.Sleep(40 * time.Millisecond)
+			return ctx.Response.PlainText("slow", http.StatusOK)
+		})
+		srv.ServeExpvar("/debug/vars")
+
+		ts = test.NewServer(nil)
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("should not count requests within the target", func() {
+		code, body, _ := test.NewGetRequest(ts.URL + "/fast")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("fast"))
+
+		Expect(breaches()).To(BeEmpty())
+	})
+
+	It("should count requests exceeding the target per route without changing the response", func() {
+		code, body, _ := test.NewGetRequest(ts.URL + "/slow")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("slow"))
+
+		test.NewGetRequest(ts.URL + "/slow")
+
+		Expect(breaches()).To(Equal(map[string]interface{}{"GET /slow": float64(2)}))
+	})
+})
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+// NewThrottleBodyMiddleware provides a middleware that limits the rate at
+// which the following middlewares can read the request body to the given
+// number of bytes per second, using a token bucket allowing bursts of one
+// second worth of bytes. This keeps clients from saturating the server with
+// uploads. It does not protect against deliberately slow clients, which is
+// what the ReadTimeout of the http.Server or `ctx.SetTimeout()` are for. Note
+// that the throttle counts towards these timeouts as well. It panics if the
+// rate is not positive, which would block reading the body forever.
+func NewThrottleBodyMiddleware(bytesPerSec int64) Middleware {
+	if bytesPerSec <= 0 {
+		panic(fmt.Sprintf("Invalid body rate of %d bytes per second. Aborting...", bytesPerSec))
+	}
+
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		if req.Body != nil && req.Body != http.NoBody {
+			req.Body = &throttledReader{
+				ReadCloser: req.Body,
+				req:        req,
+				rate:       float64(bytesPerSec),
+				tokens:     float64(bytesPerSec),
+				last:       time.Now(),
+			}
+		}
+
+		return ctx.Next()
+	}
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// throttledReader is a token bucket limited reader. Every byte read takes a
+// token, and tokens are refilled at the given rate up to the rate.
+type throttledReader struct {
+	io.ReadCloser
+
+	req    *http.Request
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return r.ReadCloser.Read(p)
+	}
+
+	for {
+		now := time.Now()
+		r.tokens += now.Sub(r.last).Seconds() * r.rate
+		if r.tokens > r.rate {
+			r.tokens = r.rate
+		}
+		r.last = now
+
+		if r.tokens >= 1 {
+			break
+		}
+
+		wait := time.Duration((1 - r.tokens) / r.rate * float64(time.Second))
+		if !sleepContext(r.req, wait) {
+			return 0, errgo.Mask(r.req.Context().Err(), errgo.Any)
+		}
+	}
+
+	if float64(len(p)) > r.tokens {
+		p = p[:int(r.tokens)]
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	r.tokens -= float64(n)
+
+	return n, err
+}
@@ -0,0 +1,77 @@
+package server_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("throttle body middleware", func() {
+	var (
+		ts      *httptest.Server
+		elapsed time.Duration
+		size    int
+	)
+
+	BeforeEach(func() {
+		ts = test.NewServer(nil)
+
+		srv := srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		srv.Serve("POST", "/upload", srvPkg.NewThrottleBodyMiddleware(50000), func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			start := time.Now()
+			content, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				return err
+			}
+			elapsed = time.Since(start)
+			size = len(content)
+
+			return ctx.Response.NoContent()
+		})
+
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("should allow bursts up to the rate", func() {
+		code, _, _ := test.NewPostRequest(ts.URL+"/upload", strings.Repeat("x", 40000), nil)
+
+		Expect(code).To(Equal(http.StatusNoContent))
+		Expect(size).To(Equal(40000))
+
+		// Without the burst, reading the body would take 800ms.
+		Expect(elapsed).To(BeNumerically("<", 500*time.Millisecond))
+	})
+
+	It("should throttle bodies exceeding the burst", func() {
+		code, _, _ := test.NewPostRequest(ts.URL+"/upload", strings.Repeat("x", 60000), nil)
+
+		Expect(code).To(Equal(http.StatusNoContent))
+		Expect(size).To(Equal(60000))
+
+		// 10000 bytes exceed the burst and take 200ms at the rate, minus the
+		// time between wrapping and reading the body. Load only makes reading
+		// slower, so this lower bound does not flake.
+		Expect(elapsed).To(BeNumerically(">=", 180*time.Millisecond))
+	})
+
+	It("should panic for a rate below 1", func() {
+		Expect(func() { srvPkg.NewThrottleBodyMiddleware(0) }).To(Panic())
+		Expect(func() { srvPkg.NewThrottleBodyMiddleware(-1) }).To(Panic())
+	})
+})
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"crypto/x509"
+	"net/http"
+)
+
+// ClientCertificate returns the certificate the client authenticated with,
+// if the connection uses TLS and the certificate was verified against the
+// ClientCAs of the tls.Config. Otherwise nil is returned.
+func (c *Context) ClientCertificate() *x509.Certificate {
+	if c.req.TLS == nil || len(c.req.TLS.VerifiedChains) == 0 || len(c.req.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return c.req.TLS.VerifiedChains[0][0]
+}
+
+// NewClientCertMiddleware provides a middleware that only lets requests pass
+// whose verified client certificate is accepted by the given function, e.g.
+// by checking its subject. Other requests are answered with
+// http.StatusForbidden. The server must be configured to request and verify
+// client certificates, see SetTLSConfig.
+func NewClientCertMiddleware(verify func(cert *x509.Certificate) bool) Middleware {
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		cert := ctx.ClientCertificate()
+		if cert == nil {
+			return ctx.Fail(http.StatusForbidden, "client certificate required")
+		}
+		if !verify(cert) {
+			return ctx.Fail(http.StatusForbidden, "client certificate not authorized")
+		}
+
+		return ctx.Next()
+	}
+}
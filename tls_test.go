@@ -0,0 +1,171 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// newTestCert creates a certificate for the given common name, signed by the
+// given parent, or self-signed if parent is nil.
+func newTestCert(cn string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer = parent.Leaf
+		signerKey = parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	Expect(err).To(BeNil())
+	leaf, err := x509.ParseCertificate(der)
+	Expect(err).To(BeNil())
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+var _ = Describe("client certificates", func() {
+	var (
+		ca     tls.Certificate
+		ts     *httptest.Server
+		client func(cert *tls.Certificate) *http.Client
+	)
+
+	BeforeEach(func() {
+		ca = newTestCert("test-ca", nil)
+		pool := x509.NewCertPool()
+		pool.AddCert(ca.Leaf)
+
+		srv := srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		requireAdmin := srvPkg.NewClientCertMiddleware(func(cert *x509.Certificate) bool {
+			return cert.Subject.CommonName == "admin"
+		})
+		srv.Serve("GET", "/admin", requireAdmin, func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.Response.PlainText("hello "+ctx.ClientCertificate().Subject.CommonName, http.StatusOK)
+		})
+
+		ts = httptest.NewUnstartedServer(srv.Router)
+		ts.TLS = &tls.Config{
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			ClientCAs:  pool,
+		}
+		ts.StartTLS()
+
+		client = func(cert *tls.Certificate) *http.Client {
+			c := ts.Client()
+			if cert != nil {
+				c.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{*cert}
+			}
+			return c
+		}
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	get := func(c *http.Client) (int, string) {
+		res, err := c.Do(test.Get(ts.URL + "/admin"))
+		Expect(err).To(BeNil())
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).To(BeNil())
+		return res.StatusCode, string(body)
+	}
+
+	It("should let authorized certificates pass", func() {
+		cert := newTestCert("admin", &ca)
+		code, body := get(client(&cert))
+
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("hello admin"))
+	})
+
+	It("should reject unauthorized certificates", func() {
+		cert := newTestCert("guest", &ca)
+		code, body := get(client(&cert))
+
+		Expect(code).To(Equal(http.StatusForbidden))
+		Expect(body).To(Equal("client certificate not authorized"))
+	})
+
+	It("should reject requests without certificate", func() {
+		code, body := get(client(nil))
+
+		Expect(code).To(Equal(http.StatusForbidden))
+		Expect(body).To(Equal("client certificate required"))
+	})
+
+	It("should be served by Listen using the TLS config", func() {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		addr := l.Addr().String()
+		Expect(l.Close()).To(Succeed())
+		host, port, err := net.SplitHostPort(addr)
+		Expect(err).To(BeNil())
+
+		pool := x509.NewCertPool()
+		pool.AddCert(ca.Leaf)
+
+		srv := srvPkg.NewServer(host, port)
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+		srv.SetTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{newTestCert("server", &ca)},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool,
+		})
+		srv.Serve("GET", "/admin", func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.Response.PlainText("hello "+ctx.ClientCertificate().Subject.CommonName, http.StatusOK)
+		})
+		go srv.Listen()
+		Eventually(srv.Started()).Should(BeClosed())
+
+		cert := newTestCert("admin", &ca)
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      pool,
+		}}}
+		res, err := c.Do(test.Get("https://" + addr + "/admin"))
+		Expect(err).To(BeNil())
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).To(BeNil())
+		Expect(string(body)).To(Equal("hello admin"))
+	})
+})
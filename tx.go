@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/juju/errgo"
+)
+
+// Tx is a transaction, e.g. a *sql.Tx, scoped to a request by the transaction
+// middleware.
+type Tx interface {
+	Commit() error
+	Rollback() error
+}
+
+// Tx returns the transaction of the current request, begun by the transaction
+// middleware, or nil if the route does not use it.
+func (c *Context) Tx() Tx {
+	return c.tx
+}
+
+// NewTxMiddleware provides a middleware that begins a transaction using begin,
+// passing the context of the request, and makes it available via `ctx.Tx()`.
+// Once the following middlewares finished, the transaction is committed if
+// commitOnSuccess is true and none of them returned an error. Otherwise, also
+// if one of them panicked, it is rolled back. An error committing the
+// transaction is returned, but note that the response might be written
+// already at that point.
+// Example: s.Serve("POST", "/v1/orders", server.NewTxMiddleware(begin, true), createOrder)
+func NewTxMiddleware(begin func(context.Context) (Tx, error), commitOnSuccess bool) Middleware {
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		tx, err := begin(req.Context())
+		if err != nil {
+			return errgo.Notef(err, "cannot begin transaction")
+		}
+
+		ctx.tx = tx
+		finished := false
+		defer func() {
+			ctx.tx = nil
+
+			// Roll back if a middleware panicked.
+			if !finished {
+				tx.Rollback()
+			}
+		}()
+
+		err = ctx.Proceed()
+		finished = true
+
+		if err != nil || !commitOnSuccess {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				ctx.server.Logger.Error(ctx.Request, "cannot roll back transaction: %#v", errgo.Mask(rollbackErr))
+			}
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return errgo.Notef(err, "cannot commit transaction")
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,144 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeTx records how it was finished.
+type fakeTx struct {
+	mutex      sync.Mutex
+	committed  bool
+	rolledBack bool
+	commitErr  error
+}
+
+func (tx *fakeTx) Commit() error {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	tx.committed = true
+	return tx.commitErr
+}
+
+func (tx *fakeTx) Rollback() error {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	tx.rolledBack = true
+	return nil
+}
+
+func (tx *fakeTx) state() (bool, bool) {
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+	return tx.committed, tx.rolledBack
+}
+
+var _ = Describe("transaction middleware", func() {
+	var (
+		ts  *httptest.Server
+		srv *srvPkg.Server
+		tx  *fakeTx
+	)
+
+	begin := func(context.Context) (srvPkg.Tx, error) {
+		return tx, nil
+	}
+
+	BeforeEach(func() {
+		logger := requestcontext.MustGetLogger(requestcontext.LoggerConfig{
+			Name:  "test",
+			Level: "critical",
+		})
+
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(logger)
+		tx = &fakeTx{}
+
+		handler := func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			Expect(ctx.Tx()).To(BeIdenticalTo(tx))
+
+			switch req.URL.Query().Get("outcome") {
+			case "error":
+				return errors.New("test error")
+			case "panic":
+				panic("test panic")
+			}
+
+			return ctx.Response.PlainText("ok", http.StatusOK)
+		}
+		srv.Serve("GET", "/write", srvPkg.NewTxMiddleware(begin, true), handler)
+		srv.Serve("GET", "/read", srvPkg.NewTxMiddleware(begin, false), handler)
+		srv.Serve("GET", "/broken", srvPkg.NewTxMiddleware(func(context.Context) (srvPkg.Tx, error) {
+			return nil, errors.New("connection refused")
+		}, true), handler)
+
+		ts = test.NewServer(nil)
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("should commit when the chain succeeded", func() {
+		code, _, _ := test.NewGetRequest(ts.URL + "/write")
+		Expect(code).To(Equal(http.StatusOK))
+
+		committed, rolledBack := tx.state()
+		Expect(committed).To(BeTrue())
+		Expect(rolledBack).To(BeFalse())
+	})
+
+	It("should roll back when the chain returned an error", func() {
+		code, _, _ := test.NewGetRequest(ts.URL + "/write?outcome=error")
+		Expect(code).To(Equal(http.StatusInternalServerError))
+
+		committed, rolledBack := tx.state()
+		Expect(committed).To(BeFalse())
+		Expect(rolledBack).To(BeTrue())
+	})
+
+	It("should roll back when the chain panicked", func() {
+		http.Get(ts.URL + "/write?outcome=panic")
+
+		committed, rolledBack := tx.state()
+		Expect(committed).To(BeFalse())
+		Expect(rolledBack).To(BeTrue())
+	})
+
+	It("should roll back unless committing on success", func() {
+		code, _, _ := test.NewGetRequest(ts.URL + "/read")
+		Expect(code).To(Equal(http.StatusOK))
+
+		committed, rolledBack := tx.state()
+		Expect(committed).To(BeFalse())
+		Expect(rolledBack).To(BeTrue())
+	})
+
+	It("should return an error if the commit failed", func() {
+		tx.commitErr = errors.New("serialization failure")
+
+		code, _, _ := test.NewGetRequest(ts.URL + "/write")
+		Expect(code).To(Equal(http.StatusOK))
+
+		committed, _ := tx.state()
+		Expect(committed).To(BeTrue())
+	})
+
+	It("should fail if the transaction cannot be begun", func() {
+		code, body, _ := test.NewGetRequest(ts.URL + "/broken")
+		Expect(code).To(Equal(http.StatusInternalServerError))
+		Expect(body).To(ContainSubstring("cannot begin transaction"))
+	})
+})
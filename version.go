@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+)
+
+// VersionInfo describes the build of a service. The values are usually
+// injected at build time, e.g.
+//
+//	var commit string // go build -ldflags "-X main.commit=$(git rev-parse HEAD)"
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+}
+
+// NewVersionMiddleware provides a middleware that responds the given build
+// information as JSON.
+func NewVersionMiddleware(info VersionInfo) Middleware {
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		return ctx.Response.Json(info, http.StatusOK)
+	}
+}
+
+// ServeVersion registers a route responding the given build information as
+// JSON. Requests to it are not access logged.
+// Example: s.ServeVersion("/version", server.VersionInfo{Version: version, Commit: commit})
+func (s *Server) ServeVersion(urlPath string, info VersionInfo) {
+	s.ServeSilent("GET", urlPath, NewVersionMiddleware(info))
+}
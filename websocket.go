@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// NewWebSocketOriginMiddleware provides a middleware that rejects WebSocket
+// upgrade requests from origins not listed in allowed with 403 Forbidden.
+// Browsers apply neither the same-origin policy nor CORS to WebSocket
+// connections, so without this check any website can open a connection using
+// the cookies of its visitors. Like for the CORS middleware, an allowed origin
+// may be "*" or contain a wildcard to allow any subdomain, e.g.
+// "https://*.example.com". Requests without an Origin header are not sent by
+// browsers and are passed on, like requests that are no WebSocket upgrades.
+// The middleware must be placed before the one upgrading the connection.
+// Example: s.Serve("GET", "/v1/events", server.NewWebSocketOriginMiddleware("https://example.com"), upgrade)
+func NewWebSocketOriginMiddleware(allowed ...string) Middleware {
+	return func(res http.ResponseWriter, req *http.Request, ctx *Context) error {
+		origin := req.Header.Get("Origin")
+		if origin == "" || !isWebSocketUpgrade(req) {
+			return ctx.Next()
+		}
+
+		for _, pattern := range allowed {
+			if pattern == "*" || matchOrigin(pattern, origin) {
+				return ctx.Next()
+			}
+		}
+
+		return ctx.Fail(http.StatusForbidden, "origin not allowed")
+	}
+}
+
+//------------------------------------------------------------------------------
+// private
+
+// isWebSocketUpgrade returns true if the client asks to upgrade the connection
+// to the WebSocket protocol.
+func isWebSocketUpgrade(req *http.Request) bool {
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+
+	for _, value := range req.Header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,74 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/giantswarm/middleware-server/test"
+	"github.com/giantswarm/request-context"
+
+	srvPkg "github.com/giantswarm/middleware-server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WebSocket origin middleware", func() {
+	var (
+		ts  *httptest.Server
+		srv *srvPkg.Server
+	)
+
+	upgrade := func(origin string) *http.Response {
+		req := test.Get(ts.URL + "/events")
+		req.Header.Set("Connection", "keep-alive, Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+
+		res, _ := test.ProcessRequest(req)
+		return res
+	}
+
+	BeforeEach(func() {
+		srv = srvPkg.NewServer("", "")
+		srv.SetLogger(requestcontext.MustGetLogger(requestcontext.LoggerConfig{Name: "test", Level: "critical"}))
+
+		// The handler stands in for the upgrade, which is not part of this package.
+		srv.Serve("GET", "/events", srvPkg.NewWebSocketOriginMiddleware("https://example.com", "https://*.example.org"), func(res http.ResponseWriter, req *http.Request, ctx *srvPkg.Context) error {
+			return ctx.Response.PlainText("upgraded", http.StatusOK)
+		})
+
+		ts = test.NewServer(nil)
+		ts.Config.Handler = srv.Router
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("Should pass on upgrades from allowed origins", func() {
+		Expect(upgrade("https://example.com").StatusCode).To(Equal(http.StatusOK))
+		Expect(upgrade("https://app.example.org").StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("Should reject upgrades from other origins", func() {
+		Expect(upgrade("https://evil.com").StatusCode).To(Equal(http.StatusForbidden))
+		Expect(upgrade("https://example.org").StatusCode).To(Equal(http.StatusForbidden))
+		Expect(upgrade("https://example.org.evil.com").StatusCode).To(Equal(http.StatusForbidden))
+	})
+
+	It("Should pass on upgrades without origin", func() {
+		Expect(upgrade("").StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("Should pass on requests that are no upgrades", func() {
+		req := test.Get(ts.URL + "/events")
+		req.Header.Set("Origin", "https://evil.com")
+
+		res, body := test.ProcessRequest(req)
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(body).To(Equal("upgraded"))
+	})
+})